@@ -3,7 +3,10 @@ package main
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
+	"mime/multipart"
 	"net/http"
+	"sort"
 	"sync"
 )
 
@@ -26,9 +29,11 @@ const (
 	statusAdmin     = 20
 )
 
+// apigen:cors {"origins": ["https://app.example.com"], "headers": ["X-Auth", "Content-Type", "Idempotency-Key"], "expose": ["ETag"], "credentials": true, "max_age": 600}
 type MyApi struct {
 	statuses map[string]int
 	users    map[string]*User
+	avatars  map[string][]byte
 	nextID   uint64
 	mu       *sync.RWMutex
 }
@@ -48,8 +53,9 @@ func NewMyApi() *MyApi {
 				Status:   statusAdmin,
 			},
 		},
-		nextID: 43,
-		mu:     &sync.RWMutex{},
+		avatars: map[string][]byte{},
+		nextID:  43,
+		mu:      &sync.RWMutex{},
 	}
 }
 
@@ -64,6 +70,18 @@ type CreateParams struct {
 	Age    int    `apivalidator:"min=0,max=128"`
 }
 
+type CreateParamsV2 struct {
+	Login  string `apivalidator:"required,min=10"`
+	Name   string `apivalidator:"required,paramname=full_name"`
+	Status string `apivalidator:"enum=user|moderator|admin,default=user"`
+}
+
+type AvatarParams struct {
+	Login  string                `apivalidator:"required"`
+	Avatar *multipart.FileHeader `apivalidator:"required,max_size=64KB,mime=image/png|image/jpeg"`
+	Thumb  []byte                `apivalidator:"max_size=8KB,mime=image/png"`
+}
+
 type User struct {
 	ID       uint64 `json:"id"`
 	Login    string `json:"login"`
@@ -75,7 +93,13 @@ type NewUser struct {
 	ID uint64 `json:"id"`
 }
 
-// apigen:api {"url": "/user/profile", "auth": false}
+type Avatar struct {
+	Login     string `json:"login"`
+	Size      int64  `json:"size"`
+	ThumbSize int    `json:"thumb_size"`
+}
+
+// apigen:api {"url": "/user/profile", "auth": false, "etag": true, "max_age": 60}
 func (srv *MyApi) Profile(ctx context.Context, in ProfileParams) (*User, error) {
 
 	if in.Login == "bad_user" {
@@ -92,7 +116,8 @@ func (srv *MyApi) Profile(ctx context.Context, in ProfileParams) (*User, error)
 	return user, nil
 }
 
-// apigen:api {"url": "/user/create", "auth": true, "method": "POST"}
+// apigen:api {"url": "/user/create", "auth": true, "method": "POST", "idempotent": true}
+// apigen:api {"url": "/user/create", "auth": true, "method": "POST", "version": "v1", "deprecated": "2026-12-31"}
 func (srv *MyApi) Create(ctx context.Context, in CreateParams) (*NewUser, error) {
 	if in.Login == "bad_username" {
 		return nil, fmt.Errorf("bad user")
@@ -118,6 +143,49 @@ func (srv *MyApi) Create(ctx context.Context, in CreateParams) (*NewUser, error)
 	return &NewUser{id}, nil
 }
 
+// apigen:api {"url": "/user/create", "auth": true, "method": "POST", "version": "v2"}
+func (srv *MyApi) CreateV2(ctx context.Context, in CreateParamsV2) (*User, error) {
+	res, err := srv.Create(ctx, CreateParams{Login: in.Login, Name: in.Name, Status: in.Status})
+	if err != nil {
+		return nil, err
+	}
+
+	srv.mu.RLock()
+	defer srv.mu.RUnlock()
+	for _, u := range srv.users {
+		if u.ID == res.ID {
+			return u, nil
+		}
+	}
+	return nil, fmt.Errorf("user %d not found", res.ID)
+}
+
+// apigen:api {"url": "/user/avatar", "auth": true, "method": "POST", "max_memory": "1MB"}
+func (srv *MyApi) UploadAvatar(ctx context.Context, in AvatarParams) (*Avatar, error) {
+	srv.mu.RLock()
+	_, exist := srv.users[in.Login]
+	srv.mu.RUnlock()
+	if !exist {
+		return nil, ApiError{http.StatusNotFound, fmt.Errorf("user not exist")}
+	}
+
+	f, err := in.Avatar.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	srv.mu.Lock()
+	srv.avatars[in.Login] = data
+	srv.mu.Unlock()
+
+	return &Avatar{in.Login, in.Avatar.Size, len(in.Thumb)}, nil
+}
+
 // 2-я часть
 // это похожая структура, с теми же методами, но у них другие параметры!
 // код, созданный вашим кодогенератором работает с конкретной струткурой, про другие ничего не знает
@@ -153,3 +221,55 @@ func (srv *OtherApi) Create(ctx context.Context, in OtherCreateParams) (*OtherUs
 		Level:    in.Level,
 	}, nil
 }
+
+// 3-я часть
+// методы с другими сигнатурами: value receiver, параметры по указателю,
+// методы без параметров и методы, возвращающие только ошибку
+
+type StatusApi struct {
+	statuses map[string]int
+}
+
+func NewStatusApi() *StatusApi {
+	return &StatusApi{
+		statuses: map[string]int{
+			"user":      statusUser,
+			"moderator": statusModerator,
+			"admin":     statusAdmin,
+		},
+	}
+}
+
+type StatusParams struct {
+	Name string `apivalidator:"required,enum=user|moderator|admin"`
+}
+
+type Status struct {
+	Name  string `json:"name"`
+	Level int    `json:"level"`
+}
+
+// apigen:api {"url": "/status/ping"}
+func (srv StatusApi) Ping(ctx context.Context) error {
+	return nil
+}
+
+// apigen:api {"url": "/status/list"}
+func (srv StatusApi) List(ctx context.Context) ([]string, error) {
+	res := make([]string, 0, len(srv.statuses))
+	for name := range srv.statuses {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+// apigen:api {"url": "/status/levels"}
+func (srv StatusApi) Levels(ctx context.Context) (map[string]int, error) {
+	return srv.statuses, nil
+}
+
+// apigen:api {"url": "/status/get"}
+func (srv StatusApi) Get(ctx context.Context, in *StatusParams) (Status, error) {
+	return Status{in.Name, srv.statuses[in.Name]}, nil
+}
@@ -1,39 +1,554 @@
 // go build gen/* && ./codegen.exe pack/unpack.go  pack/marshaller.go
-// go run pack/*
+// go run ./pack
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"go/ast"
+	"go/format"
 	"go/parser"
 	"go/token"
+	"io/ioutil"
 	"log"
 	"os"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 	"text/template"
 )
 
-type tpl struct {
-	FieldName string
+const binpackMark = "// cgen: binpack"
+
+// binField is a packed struct field with the code generated for it
+type binField struct {
+	Name       string
+	Type       *binType
+	Max        int // max length of a string, []byte, slice or marshaler, 0 if not limited
+	Decode     string
+	DecodeFast string // decoding of UnpackFast, set only for fast structs
+	Encode     string
+	Check      string // empty if any value of the field can be packed
+	Size       string // adding the packed size of the field in PackedSize, empty if it is in FixedSize
+	Sample     string // Go literal used in generated tests
+	TooLong    string // Go literal longer than Max, used in generated tests, empty if the prefix can not hold it
+	Since      int    // first version of a versioned struct with the field
+	Zero       string // Go literal the field is set to when a record is older than Since
+	Extreme    string // Go literal with values at the edges of the type ranges, used in fuzz seeds
+}
+
+// binStruct is a struct marked with
+//
+//	// cgen: binpack [strict] [le|be] [fast] [version=N] [crc=ieee|castagnoli[:Field]] ["perl pack template"]
+//
+// strict makes Unpack reject data with bytes after the last field,
+// le and be set the default byte order of fields, little endian if not set,
+// fast adds UnpackFast decoding by offsets and benchmarks comparing it with Unpack,
+// version makes records versioned: Pack writes version N and the record length,
+// Unpack reads fields tagged since=M only from records of version M and newer
+// and skips fields of versions newer than N, crc adds a crc32 checksum of the fields up to
+// Field or of all of them right after the last covered one. A pack template is checked to match
+// the fields, see perl.go.
+type binStruct struct {
+	Name    string
+	Strict  bool
+	Order   string
+	Fast    bool
+	Version int  // 0 if records are not versioned
+	Aliased bool // has fields tagged alias
+	Fields  []*binField
+
+	FixedSize int // packed size of the header, the checksum and fields taking the same size for any value
+
+	CRC      string // crc32 polynomial, "ieee" or "castagnoli", empty if there is no checksum
+	CRCTable string // Go expression of the crc32 table
+	CRCAfter string // the last field covered by the checksum
+
+	PackTemplate string // perl pack template of the layout, empty if it has none
 }
 
 var (
-	intTpl = template.Must(template.New("intTpl").Parse(`
-	// {{.FieldName}}
-	var {{.FieldName}}Raw uint32
-	binary.Read(r, binary.LittleEndian, &{{.FieldName}}Raw)
-	in.{{.FieldName}} = int({{.FieldName}}Raw)
+	helpersTpl = template.Must(template.New("helpersTpl").Parse(`
+// ErrTruncated is returned by Unpack when data ends before Field is read completely.
+type ErrTruncated struct {
+	Field  string
+	Offset int
+}
+
+func (e *ErrTruncated) Error() string {
+	return fmt.Sprintf("binpack: %s truncated at offset %d", e.Field, e.Offset)
+}
+
+// ErrTooLong is returned by Unpack when a length of Field exceeds its cgen max tag.
+type ErrTooLong struct {
+	Field  string
+	Offset int
+	Len    uint64
+	Max    int
+}
+
+func (e *ErrTooLong) Error() string {
+	return fmt.Sprintf("binpack: %s length %d at offset %d exceeds max %d", e.Field, e.Len, e.Offset, e.Max)
+}
+
+// ErrTrailingData is returned by Unpack of strict structs when data has bytes after the last field,
+// and of versioned ones when a record of a known version has bytes after its last field.
+type ErrTrailingData struct {
+	Type   string
+	Offset int
+}
+
+func (e *ErrTrailingData) Error() string {
+	return fmt.Sprintf("binpack: %s ends at offset %d, data has trailing bytes", e.Type, e.Offset)
+}
+
+func binpackOffset(r *bytes.Reader) int {
+	return int(r.Size()) - r.Len()
+}
+
+func binpackRead(r *bytes.Reader, order binary.ByteOrder, field string, v interface{}) error {
+	off := binpackOffset(r)
+	if err := binary.Read(r, order, v); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return &ErrTruncated{field, off}
+		}
+		return err
+	}
+	return nil
+}
+
+// binpackReadUint reads an unsigned integer of size bytes, size 0 means a varint.
+func binpackReadUint(r *bytes.Reader, order binary.ByteOrder, size int, field string) (uint64, error) {
+	switch size {
+	case 0:
+		return binpackReadUvarint(r, field)
+	case 1:
+		var v uint8
+		err := binpackRead(r, order, field, &v)
+		return uint64(v), err
+	case 2:
+		var v uint16
+		err := binpackRead(r, order, field, &v)
+		return uint64(v), err
+	case 4:
+		var v uint32
+		err := binpackRead(r, order, field, &v)
+		return uint64(v), err
+	}
+	var v uint64
+	err := binpackRead(r, order, field, &v)
+	return v, err
+}
+
+// binpackReadInt reads a signed integer of size bytes.
+func binpackReadInt(r *bytes.Reader, order binary.ByteOrder, size int, field string) (int64, error) {
+	v, err := binpackReadUint(r, order, size, field)
+	switch size {
+	case 1:
+		return int64(int8(v)), err
+	case 2:
+		return int64(int16(v)), err
+	case 4:
+		return int64(int32(v)), err
+	}
+	return int64(v), err
+}
+
+func binpackReadUvarint(r *bytes.Reader, field string) (uint64, error) {
+	off := binpackOffset(r)
+	v, err := binary.ReadUvarint(r)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return 0, &ErrTruncated{field, off}
+	}
+	if err != nil {
+		return 0, fmt.Errorf("binpack: %s at offset %d: %s", field, off, err)
+	}
+	return v, nil
+}
+
+func binpackReadVarint(r *bytes.Reader, field string) (int64, error) {
+	off := binpackOffset(r)
+	v, err := binary.ReadVarint(r)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return 0, &ErrTruncated{field, off}
+	}
+	if err != nil {
+		return 0, fmt.Errorf("binpack: %s at offset %d: %s", field, off, err)
+	}
+	return v, nil
+}
+
+// binpackReadLen reads a length prefix of size bytes and checks it against max and,
+// as every element takes at least elemSize bytes, against the rest of data. So a broken
+// or malicious length never makes the caller allocate more than data could hold.
+func binpackReadLen(r *bytes.Reader, order binary.ByteOrder, size int, field string, max, elemSize int) (int, error) {
+	off := binpackOffset(r)
+	n, err := binpackReadUint(r, order, size, field)
+	if err != nil {
+		return 0, err
+	}
+	if max > 0 && n > uint64(max) {
+		return 0, &ErrTooLong{field, off, n, max}
+	}
+	if elemSize > 0 && n > uint64(r.Len()/elemSize) {
+		return 0, &ErrTruncated{field, int(r.Size())}
+	}
+	return int(n), nil
+}
+
+func binpackReadBytes(r *bytes.Reader, order binary.ByteOrder, size int, field string, max int) ([]byte, error) {
+	n, err := binpackReadLen(r, order, size, field, max, 1)
+	if err != nil || n == 0 {
+		return nil, err
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, &ErrTruncated{field, binpackOffset(r)}
+	}
+	return buf, nil
+}
 `))
 
-	strTpl = template.Must(template.New("strTpl").Parse(`
-	// {{.FieldName}}
-	var {{.FieldName}}LenRaw uint32
-	binary.Read(r, binary.LittleEndian, &{{.FieldName}}LenRaw)
-	{{.FieldName}}Raw := make([]byte, {{.FieldName}}LenRaw)
-	binary.Read(r, binary.LittleEndian, &{{.FieldName}}Raw)
-	in.{{.FieldName}} = string({{.FieldName}}Raw)
+	unpackTpl = template.Must(template.New("unpackTpl").Parse(`
+// Unpack reads in from data in the layout written by Pack.
+// Errors are *ErrTruncated, *ErrTooLong{{if or .Strict .Version}}, *ErrTrailingData{{end}}{{if .CRC}}, *ErrChecksum{{end}} or report values which do not fit Go types.
+func (in *{{.Name}}) Unpack(data []byte) error {
+	r := bytes.NewReader(data)
+	if err := in.binpackUnpack(r); err != nil {
+		return err
+	}
+	{{if .Strict}}if r.Len() > 0 {
+		return &ErrTrailingData{"{{.Name}}", binpackOffset(r)}
+	}
+	{{end}}return nil
+}
+
+func (in *{{.Name}}) binpackUnpack(r *bytes.Reader) error {
+{{if .Version}}	version, end, err := binpackReadVersion(r, {{.Order}}, "{{.Name}}")
+	if err != nil {
+		return err
+	}
+
+{{end}}{{if .CRC}}	start := binpackOffset(r)
+
+{{end}}{{range .Fields}}	// {{.Name}}
+	{{if gt .Since 1}}if version >= {{.Since}} {
+		{{.Decode}}
+	} else {
+		in.{{.Name}} = {{.Zero}}
+	}{{else}}{{.Decode}}{{end}}
+	{{if eq .Name $.CRCAfter}}
+	// checksum
+	if err := binpackCheckCRC(r, {{$.Order}}, {{$.CRCTable}}, "{{$.Name}}", start); err != nil {
+		return err
+	}
+	{{end}}{{if $.Version}}if binpackOffset(r) > end {
+		return &ErrTruncated{"{{$.Name}}.{{.Name}}", end}
+	}
+	{{end}}
+{{end}}{{if .Version}}	return binpackSkipRecord(r, "{{.Name}}", version, {{.Version}}, end){{else}}	return nil{{end}}
+}
 `))
+
+	packTpl = template.Must(template.New("packTpl").Parse(`{{if .PackTemplate}}
+// {{.Name}}PackTemplate is the perl pack template of the {{.Name}} layout.
+const {{.Name}}PackTemplate = "{{.PackTemplate}}"
+{{end}}
+// Pack returns the binary representation of in, it is read back by Unpack.
+func (in *{{.Name}}) Pack() ([]byte, error) {
+	if err := in.binpackCheck(); err != nil {
+		return nil, err
+	}
+	return in.AppendPack(make([]byte, 0, in.PackedSize())), nil
+}
+
+// binpackCheck reports values AppendPack can not represent or Unpack would reject, like too long strings.
+func (in *{{.Name}}) binpackCheck() error {
+{{range .Fields}}{{if .Check}}	// {{.Name}}
+	{{.Check}}
+
+{{end}}{{end}}	return nil
+}
+
+// AppendPack appends the binary representation of in to dst.
+// Values must fit the wire types, Pack checks it.
+func (in *{{.Name}}) AppendPack(dst []byte) []byte {
+{{if .Version}}	return in.binpackAppendVersion(dst, {{.Version}})
+}
+
+// binpackAppendVersion appends a record of the version, without fields newer than it,
+// for readers which do not know the current version yet.
+func (in *{{.Name}}) binpackAppendVersion(dst []byte, version int) []byte {
+	// the record length is set when the fields are appended
+	dst = {{.Order}}.AppendUint16(dst, uint16(version))
+	dst = append(dst, 0, 0, 0, 0)
+	start := len(dst)
+
+{{else if .CRC}}	start := len(dst)
+
+{{end}}{{range .Fields}}	// {{.Name}}
+	{{if gt .Since 1}}if version >= {{.Since}} {
+		{{.Encode}}
+	}{{else}}{{.Encode}}{{end}}
+	{{if eq .Name $.CRCAfter}}
+	// checksum
+	dst = {{$.Order}}.AppendUint32(dst, crc32.Checksum(dst[start:], {{$.CRCTable}}))
+	{{end}}
+
+{{end}}{{if .Version}}	{{.Order}}.PutUint32(dst[start-4:], uint32(len(dst)-start))
+{{end}}	return dst
+}
+`))
+
+	testTpl = template.Must(template.New("testTpl").Parse(`
+func binpackSample{{.Name}}() {{.Name}} {
+	return {{.Name}}{
+		{{range .Fields}}{{.Name}}: {{.Sample}},
+		{{end}}}
+}
+
+func Test{{.Name}}PackRoundTrip(t *testing.T) {
+	in := binpackSample{{.Name}}()
+
+	data, err := in.Pack()
+	if err != nil {
+		t.Fatalf("Pack: %s", err)
+	}
+	if appended := in.AppendPack([]byte{0xff}); !bytes.Equal(appended[1:], data) || appended[0] != 0xff {
+		t.Fatalf("AppendPack = %v, expected 0xff followed by %v", appended, data)
+	}
+	if size := in.PackedSize(); size != len(data) || cap(data) != len(data) {
+		t.Fatalf("PackedSize = %d, packed %d bytes with capacity %d", size, len(data), cap(data))
+	}
+
+	out := {{.Name}}{}
+	if err := out.Unpack(data); err != nil {
+		t.Fatalf("Unpack: %s", err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("round trip mismatch\nin:  %#v\nout: %#v", in, out)
+	}
+	{{if .Fast}}
+	fast := {{.Name}}{}
+	if err := fast.UnpackFast(data); err != nil {
+		t.Fatalf("UnpackFast: %s", err)
+	}
+	if !reflect.DeepEqual(in, fast) {
+		t.Fatalf("UnpackFast round trip mismatch\nin:  %#v\nout: %#v", in, fast)
+	}
+	{{end}}{{if .Strict}}
+	if err := out.Unpack(append(data, 0)); !errors.As(err, new(*ErrTrailingData)) {
+		t.Fatalf("Unpack with a trailing byte: got %v, expected *ErrTrailingData", err)
+	}
+	{{if .Fast}}if err := out.UnpackFast(append(data, 0)); !errors.As(err, new(*ErrTrailingData)) {
+		t.Fatalf("UnpackFast with a trailing byte: got %v, expected *ErrTrailingData", err)
+	}
+	{{end}}{{end}}
+}
+
+func Test{{.Name}}Gob(t *testing.T) {
+	var _ encoding.BinaryMarshaler = &{{.Name}}{}
+	var _ encoding.BinaryUnmarshaler = &{{.Name}}{}
+
+	in := binpackSample{{.Name}}()
+	buf := &bytes.Buffer{}
+	if err := gob.NewEncoder(buf).Encode(&in); err != nil {
+		t.Fatalf("gob Encode: %s", err)
+	}
+	out := {{.Name}}{}
+	if err := gob.NewDecoder(buf).Decode(&out); err != nil {
+		t.Fatalf("gob Decode: %s", err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("gob round trip mismatch\nin:  %#v\nout: %#v", in, out)
+	}
+}
+
+func Test{{.Name}}UnpackTruncated(t *testing.T) {
+	in := binpackSample{{.Name}}()
+	data, err := in.Pack()
+	if err != nil {
+		t.Fatalf("Pack: %s", err)
+	}
+	for i := 0; i < len(data); i++ {
+		out := {{.Name}}{}
+		err := out.Unpack(data[:i])
+		if !errors.As(err, new(*ErrTruncated)) {
+			t.Fatalf("Unpack of %d bytes out of %d: got %v, expected *ErrTruncated", i, len(data), err)
+		}
+		{{if .Fast}}if fastErr := out.UnpackFast(data[:i]); fmt.Sprint(fastErr) != fmt.Sprint(err) {
+			t.Fatalf("UnpackFast of %d bytes out of %d: got %v, expected %v", i, len(data), fastErr, err)
+		}
+		{{end}}
+	}
+}
+
+func Test{{.Name}}Frames(t *testing.T) {
+	in := binpackSample{{.Name}}()
+	stream := &bytes.Buffer{}
+	fw := NewFrameWriter(stream)
+	for i := 0; i < 3; i++ {
+		if err := fw.Write(&in); err != nil {
+			t.Fatalf("FrameWriter.Write: %s", err)
+		}
+	}
+	n, err := in.WriteTo(stream)
+	if err != nil {
+		t.Fatalf("WriteTo: %s", err)
+	}
+	frame := stream.Bytes()[stream.Len()-int(n):]
+
+	// one byte reads check partial reads are completed
+	r := iotest.OneByteReader(bytes.NewReader(stream.Bytes()))
+	fr := NewFrameReader(r, 0)
+	for i := 0; i < 3; i++ {
+		out := {{.Name}}{}
+		if err := fr.Next(&out); err != nil {
+			t.Fatalf("FrameReader.Next of frame %d: %s", i, err)
+		}
+		if !reflect.DeepEqual(in, out) {
+			t.Fatalf("frame %d mismatch\nin:  %#v\nout: %#v", i, in, out)
+		}
+	}
+	out := {{.Name}}{}
+	if m, err := out.ReadFrom(r); err != nil || m != n || !reflect.DeepEqual(in, out) {
+		t.Fatalf("ReadFrom: read %d bytes of %d, error %v\nin:  %#v\nout: %#v", m, n, err, in, out)
+	}
+	if err := fr.Next(&out); err != io.EOF {
+		t.Fatalf("FrameReader.Next at the end: got %v, expected io.EOF", err)
+	}
+
+	for i := 1; i < len(frame); i++ {
+		if _, err := out.ReadFrom(bytes.NewReader(frame[:i])); err != io.ErrUnexpectedEOF {
+			t.Fatalf("ReadFrom of %d bytes out of %d: got %v, expected io.ErrUnexpectedEOF", i, len(frame), err)
+		}
+	}
+	if err := NewFrameReader(bytes.NewReader(frame), len(frame)-5).Next(&out); !errors.As(err, new(*ErrTooLong)) {
+		t.Fatalf("FrameReader.Next of a frame over max: got %v, expected *ErrTooLong", err)
+	}
+}
+{{if .CRC}}
+func Test{{.Name}}Checksum(t *testing.T) {
+	in := binpackSample{{.Name}}()
+	data, err := in.Pack()
+	if err != nil {
+		t.Fatalf("Pack: %s", err)
+	}
+	checksums := 0
+	{{if .Version}}// a changed version is a valid record of another version
+	{{end}}for i := {{if .Version}}2{{else}}0{{end}}; i < len(data); i++ {
+		bad := append([]byte(nil), data...)
+		bad[i] ^= 0x10
+		out := {{.Name}}{}
+		err := out.Unpack(bad)
+		if err == nil && reflect.DeepEqual(in, out) {
+			t.Fatalf("Unpack with a flipped bit in byte %d returned the packed value", i)
+		}
+		if errors.As(err, new(*ErrChecksum)) {
+			checksums++
+		}
+		{{if .Fast}}if fastErr := out.UnpackFast(bad); fmt.Sprint(fastErr) != fmt.Sprint(err) {
+			t.Fatalf("UnpackFast with a flipped bit in byte %d: got %v, expected %v", i, fastErr, err)
+		}
+		{{end}}
+	}
+	if checksums == 0 {
+		t.Fatal("no flipped bit was reported as *ErrChecksum")
+	}
+}
+{{end}}{{if .Version}}
+func Test{{.Name}}Versions(t *testing.T) {
+	in := binpackSample{{.Name}}()
+	for version := 1; version < {{.Version}}; version++ {
+		expected := in
+		{{range .Fields}}{{if gt .Since 1}}if version < {{.Since}} {
+			expected.{{.Name}} = {{.Zero}}
+		}
+		{{end}}{{end}}
+		// decoded into a filled value, fields missing in old records must be reset
+		out := binpackSample{{.Name}}()
+		if err := out.Unpack(in.binpackAppendVersion(nil, version)); err != nil {
+			t.Fatalf("Unpack of version %d: %s", version, err)
+		}
+		if !reflect.DeepEqual(expected, out) {
+			t.Fatalf("version %d mismatch\nexpected: %#v\nout:      %#v", version, expected, out)
+		}
+		{{if .Fast}}fast := binpackSample{{.Name}}()
+		if err := fast.UnpackFast(in.binpackAppendVersion(nil, version)); err != nil || !reflect.DeepEqual(expected, fast) {
+			t.Fatalf("UnpackFast of version %d: error %v\nexpected: %#v\nout:      %#v", version, err, expected, fast)
+		}
+		{{end}}
+	}
+
+	// a record of a newer version with a field unknown yet
+	data := in.AppendPack(nil)
+	data = append(data, 1, 2, 3)
+	{{.Order}}.PutUint16(data, {{.Version}}+1)
+	{{.Order}}.PutUint32(data[2:], uint32(len(data)-6))
+	out := {{.Name}}{}
+	if err := out.Unpack(data); err != nil || !reflect.DeepEqual(in, out) {
+		t.Fatalf("Unpack of a newer version: error %v\nin:  %#v\nout: %#v", err, in, out)
+	}
+	{{if .Fast}}if err := out.UnpackFast(data); err != nil || !reflect.DeepEqual(in, out) {
+		t.Fatalf("UnpackFast of a newer version: error %v\nin:  %#v\nout: %#v", err, in, out)
+	}
+	{{end}}
+	// the same bytes are corrupted data for the current version
+	{{.Order}}.PutUint16(data, {{.Version}})
+	if err := out.Unpack(data); !errors.As(err, new(*ErrTrailingData)) {
+		t.Fatalf("Unpack of a longer record: got %v, expected *ErrTrailingData", err)
+	}
+}
+{{end}}{{range .Fields}}{{if .TooLong}}
+func Test{{$.Name}}{{.Name}}TooLong(t *testing.T) {
+	in := binpackSample{{$.Name}}()
+	in.{{.Name}} = {{.TooLong}}
+	if _, err := in.Pack(); err == nil {
+		t.Fatal("Pack accepted {{.Name}} longer than max")
+	}
+	out := {{$.Name}}{}
+	if err := out.Unpack(in.AppendPack(nil)); !errors.As(err, new(*ErrTooLong)) {
+		t.Fatalf("Unpack: got %v, expected *ErrTooLong", err)
+	}
+	{{if $.Fast}}if err := out.UnpackFast(in.AppendPack(nil)); !errors.As(err, new(*ErrTooLong)) {
+		t.Fatalf("UnpackFast: got %v, expected *ErrTooLong", err)
+	}
+	{{end}}
+}
+{{end}}{{end}}{{if .Fast}}
+func Benchmark{{.Name}}Unpack(b *testing.B) {
+	in := binpackSample{{.Name}}()
+	data, err := in.Pack()
+	if err != nil {
+		b.Fatalf("Pack: %s", err)
+	}
+	out := {{.Name}}{}
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := out.Unpack(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func Benchmark{{.Name}}UnpackFast(b *testing.B) {
+	in := binpackSample{{.Name}}()
+	data, err := in.Pack()
+	if err != nil {
+		b.Fatalf("Pack: %s", err)
+	}
+	out := {{.Name}}{}
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := out.UnpackFast(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+{{end}}`))
 )
 
 func main() {
@@ -43,21 +558,358 @@ func main() {
 		log.Fatal(err)
 	}
 
-	out, _ := os.Create(os.Args[2])
+	info := typeCheck(fset, node)
+	marked := collectMarked(node)
+	markedNames := make(map[string]bool, len(marked))
+	for _, m := range marked {
+		markedNames[m.Spec.Name.Name] = true
+	}
+	g := &generator{
+		imports: map[string]bool{"bytes": true, "encoding/binary": true, "fmt": true, "io": true},
+		structs: make(map[string]*binStruct),
+	}
+
+	// types of all marked structs are parsed first, so they can be nested in any order
+	var structs []*binStruct
+	for _, m := range marked {
+		ts := m.Spec
+		fmt.Printf("process struct %s\n", ts.Name.Name)
+		st := &binStruct{Name: ts.Name.Name, Order: "binary.LittleEndian"}
+		for _, opt := range m.Options {
+			switch opt {
+			case "strict":
+				st.Strict = true
+			case "le", "be":
+				st.Order = byteOrders[opt]
+			case "fast":
+				st.Fast = true
+			default:
+				if strings.HasPrefix(opt, "crc=") {
+					kv := strings.SplitN(strings.TrimPrefix(opt, "crc="), ":", 2)
+					if crcTables[kv[0]] == "" {
+						log.Fatalf("%s: %s: crc must be ieee or castagnoli in %q", fset.Position(ts.Pos()), st.Name, opt)
+					}
+					st.CRC, st.CRCTable = kv[0], crcTables[kv[0]]
+					if len(kv) == 2 {
+						st.CRCAfter = kv[1]
+					}
+					continue
+				}
+				if strings.HasPrefix(opt, "version=") {
+					if st.Version, err = strconv.Atoi(strings.TrimPrefix(opt, "version=")); err != nil || st.Version < 1 || st.Version > 0xffff {
+						log.Fatalf("%s: %s: bad version in %q", fset.Position(ts.Pos()), st.Name, opt)
+					}
+					continue
+				}
+				log.Fatalf("%s: %s: unknown binpack option %q", fset.Position(ts.Pos()), st.Name, opt)
+			}
+		}
 
-	fmt.Fprintln(out, `package `+node.Name.Name)
-	fmt.Fprintln(out) // empty line
-	fmt.Fprintln(out, `import "encoding/binary"`)
-	fmt.Fprintln(out, `import "bytes"`)
-	fmt.Fprintln(out) // empty line
+		for _, field := range ts.Type.(*ast.StructType).Fields.List {
+			tag := ""
+			if field.Tag != nil {
+				tag = reflect.StructTag(field.Tag.Value[1 : len(field.Tag.Value)-1]).Get("cgen")
+			}
+			if tag == "-" {
+				continue
+			}
+			if len(field.Names) == 0 {
+				log.Fatalf("%s: %s: embedded fields are not supported", fset.Position(field.Pos()), st.Name)
+			}
+			t, err := parseType(field.Type, markedNames, info)
+			if err != nil {
+				log.Fatalf("%s: %s.%s: %s", fset.Position(field.Pos()), st.Name, field.Names[0].Name, err)
+			}
+			max, since, err := parseFieldTag(tag, t, st.Order)
+			switch {
+			case err != nil:
+			case since > 1 && st.Version == 0:
+				err = fmt.Errorf("since requires the version option of the struct")
+			case since > st.Version && st.Version > 0:
+				err = fmt.Errorf("since=%d is newer than the struct version %d", since, st.Version)
+			}
+			if err == nil && !st.Fast && hasAlias(t) {
+				err = fmt.Errorf("alias requires the fast option of the struct")
+			}
+			if err == nil && st.Fast {
+				err = checkNestedFast(t, marked)
+			}
+			if err != nil {
+				log.Fatalf("%s: %s.%s: %s", fset.Position(field.Pos()), st.Name, field.Names[0].Name, err)
+			}
+			st.Aliased = st.Aliased || hasAlias(t)
+			for _, name := range field.Names {
+				st.Fields = append(st.Fields, &binField{Name: name.Name, Type: t, Max: max, Since: since, Zero: zeroValue(t)})
+			}
+		}
+		if st.CRC != "" {
+			if err := checkCRCAfter(st); err != nil {
+				log.Fatalf("%s: %s: %s", fset.Position(ts.Pos()), st.Name, err)
+			}
+			g.imports["hash/crc32"] = true
+		}
+		g.structs[st.Name] = st
+		structs = append(structs, st)
+	}
 
+	for i, st := range structs {
+		tpl, err := g.packTemplate(st)
+		if marked[i].Template != "" {
+			tpl, err = g.checkTemplate(st, marked[i].Template)
+			if err != nil {
+				log.Fatalf("%s: %s: %s", fset.Position(marked[i].Spec.Pos()), st.Name, err)
+			}
+		}
+		if err != nil {
+			fmt.Printf("SKIP pack template of %s: %s\n", st.Name, err)
+		}
+		st.PackTemplate = tpl
+	}
+
+	out := &bytes.Buffer{}
+	tests := &bytes.Buffer{}
+
+	if err := helpersTpl.Execute(out, nil); err != nil {
+		log.Fatal(err)
+	}
+	if err := streamHelpersTpl.Execute(out, nil); err != nil {
+		log.Fatal(err)
+	}
+	if err := sizeHelpersTpl.Execute(out, nil); err != nil {
+		log.Fatal(err)
+	}
+	anyFast, anyVersioned := false, false
+	for _, st := range structs {
+		anyFast = anyFast || st.Fast
+		anyVersioned = anyVersioned || st.Version > 0
+	}
+	if anyFast {
+		if err := fastHelpersTpl.Execute(out, nil); err != nil {
+			log.Fatal(err)
+		}
+	}
+	anyCRC := false
+	for _, st := range structs {
+		anyCRC = anyCRC || st.CRC != ""
+	}
+	if anyCRC {
+		if err := crcHelpersTpl.Execute(out, anyFast); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if anyVersioned {
+		if err := versionHelpersTpl.Execute(out, anyFast); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	testImports := []string{"bytes", "encoding", "encoding/gob", "errors", "io", "reflect", "testing", "testing/iotest"}
+	for _, st := range structs {
+		for _, f := range st.Fields {
+			fmt.Printf("\tgenerating code for field %s.%s\n", st.Name, f.Name)
+			target, path := "in."+f.Name, st.Name+"."+f.Name
+			f.Decode = g.decode(f.Type, target, path, f.Max, 0)
+			if st.Fast {
+				f.DecodeFast = g.decodeFast(f.Type, target, path, f.Max, 0, false)
+			}
+			f.Encode = g.encode(f.Type, target, 0)
+			f.Check = g.check(f.Type, target, path, f.Max, 0)
+		}
+		if st.Version > 0 {
+			st.FixedSize = 6
+		}
+		if st.CRC != "" {
+			st.FixedSize += 4
+		}
+		for _, f := range st.Fields {
+			if n, ok := g.constSize(f.Type); ok {
+				st.FixedSize += n
+			} else {
+				f.Size = g.size(f.Type, "in."+f.Name, 0)
+			}
+		}
+		for i, f := range st.Fields {
+			f.Sample = g.sample(f.Type, f.Name, i+1, f.Max, 0)
+			f.Extreme = g.extreme(f.Type, f.Max, 0)
+			for _, pkg := range []string{"math", "strings"} {
+				if strings.Contains(f.Extreme, pkg+".") {
+					testImports = append(testImports, pkg)
+				}
+			}
+			// Unpack can see a too long value only if its length fits the prefix
+			if f.Max > 0 && f.Type.Kind != "marshaler" && (f.Type.Prefix == "varint" || uint64(f.Max+1)>>(8*uint(wireSizes[f.Type.Prefix])) == 0) {
+				f.TooLong = tooLong(f.Type, f.Max)
+				if f.Type.Kind == "string" {
+					testImports = append(testImports, "strings")
+				}
+			}
+		}
+
+		fmt.Printf("\tgenerating Unpack, Pack, AppendPack, PackedSize, ReadFrom, WriteTo and binary marshaler methods for %s\n", st.Name)
+		if err := unpackTpl.Execute(out, st); err != nil {
+			log.Fatal(err)
+		}
+		if err := streamTpl.Execute(out, st); err != nil {
+			log.Fatal(err)
+		}
+		if st.Fast {
+			fmt.Printf("\tgenerating UnpackFast method for %s\n", st.Name)
+			if err := unpackFastTpl.Execute(out, st); err != nil {
+				log.Fatal(err)
+			}
+			testImports = append(testImports, "fmt")
+		}
+		if err := packTpl.Execute(out, st); err != nil {
+			log.Fatal(err)
+		}
+		if err := marshalerTpl.Execute(out, st); err != nil {
+			log.Fatal(err)
+		}
+		if st.Version > 0 {
+			testImports = append(testImports, "encoding/binary")
+		}
+		if err := testTpl.Execute(tests, st); err != nil {
+			log.Fatal(err)
+		}
+		if err := fuzzTpl.Execute(tests, st); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// packages of marshaler fields are imported as the source file does
+	imports := fileImports(node)
+	writeSource(os.Args[2], node.Name.Name, uniqueSorted(append(g.importList(), usedImports(out.Bytes(), imports)...)), out.Bytes())
+	testImports = append(testImports, usedImports(tests.Bytes(), imports)...)
+	writeSource(strings.TrimSuffix(os.Args[2], ".go")+"_test.go", node.Name.Name, uniqueSorted(testImports), tests.Bytes())
+}
+
+var byteOrders = map[string]string{
+	"le": "binary.LittleEndian",
+	"be": "binary.BigEndian",
+}
+
+// parseFieldTag parses a cgen field tag and applies it to the field type.
+// The tag is a comma separated list of options:
+//
+//	max=N          max length of a string, []byte, slice or MarshalBinary bytes, longer values are rejected by Pack and Unpack
+//	le, be         byte order of the field, the struct default if not set
+//	u8 ... u64     wire width of an integer or integer elements,
+//	i8 ... i64     the value must fit it
+//	varint         integers as varints, zigzag encoded for signed Go types
+//	len=W          length prefix of a string, []byte, slice or marshaler, W is u8 ... u64 or varint, u32 if not set
+//	alias          UnpackFast of a fast struct does not copy strings and []byte, they share memory with data
+//	since=N        first version of a versioned struct with the field, 1 if not set
+func parseFieldTag(tag string, t *binType, order string) (max, since int, err error) {
+	since = 1
+	wire, prefix, alias := "", "", false
+	if tag != "" {
+		for _, opt := range strings.Split(tag, ",") {
+			kv := strings.SplitN(strings.TrimSpace(opt), "=", 2)
+			switch {
+			case kv[0] == "max":
+				if t.Kind != "string" && t.Kind != "bytes" && t.Kind != "slice" && t.Kind != "marshaler" {
+					return 0, 0, fmt.Errorf("max is supported only for strings, []byte, slices and marshalers")
+				}
+				if len(kv) != 2 {
+					return 0, 0, fmt.Errorf("max requires a value")
+				}
+				if max, err = strconv.Atoi(kv[1]); err != nil || max <= 0 {
+					return 0, 0, fmt.Errorf("bad max %q", kv[1])
+				}
+			case kv[0] == "len":
+				if len(kv) != 2 || (kv[1] != "varint" && (wireSizes[kv[1]] == 0 || kv[1][0] != 'u')) {
+					return 0, 0, fmt.Errorf("len must be one of u8, u16, u32, u64 or varint")
+				}
+				prefix = kv[1]
+			case kv[0] == "since":
+				if len(kv) != 2 {
+					return 0, 0, fmt.Errorf("since requires a value")
+				}
+				if since, err = strconv.Atoi(kv[1]); err != nil || since < 1 {
+					return 0, 0, fmt.Errorf("bad since %q", kv[1])
+				}
+			case kv[0] == "alias":
+				alias = true
+			case byteOrders[kv[0]] != "":
+				order = byteOrders[kv[0]]
+			case wireSizes[kv[0]] > 0 || kv[0] == "varint":
+				wire = kv[0]
+			default:
+				return 0, 0, fmt.Errorf("unknown cgen option %q", opt)
+			}
+		}
+	}
+	if err := t.applyOptions(order, wire, prefix); err != nil {
+		return 0, 0, err
+	}
+	if alias {
+		if err := t.setAlias(); err != nil {
+			return 0, 0, err
+		}
+	}
+	if max > 0 && t.Prefix != "varint" && uint64(max)>>(8*uint(wireSizes[t.Prefix])) != 0 {
+		return 0, 0, fmt.Errorf("max %d does not fit the %s length", max, t.Prefix)
+	}
+	return max, since, nil
+}
+
+// checkCRCAfter sets the last field covered by the checksum to the last field if it is not set
+func checkCRCAfter(st *binStruct) error {
+	if len(st.Fields) == 0 {
+		return fmt.Errorf("crc of a struct without fields")
+	}
+	if st.CRCAfter == "" {
+		st.CRCAfter = st.Fields[len(st.Fields)-1].Name
+		return nil
+	}
+	for _, f := range st.Fields {
+		if f.Name == st.CRCAfter {
+			return nil
+		}
+	}
+	return fmt.Errorf("crc field %s is not a packed field", st.CRCAfter)
+}
+
+func hasAlias(t *binType) bool {
+	return t.Alias || t.Elem != nil && hasAlias(t.Elem)
+}
+
+// checkNestedFast reports structs nested in a fast struct which are not fast themselves
+func checkNestedFast(t *binType, marked []markedStruct) error {
+	if t.Elem != nil {
+		return checkNestedFast(t.Elem, marked)
+	}
+	if t.Kind != "struct" {
+		return nil
+	}
+	for _, m := range marked {
+		if m.Spec.Name.Name != t.Name {
+			continue
+		}
+		for _, opt := range m.Options {
+			if opt == "fast" {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("nested struct %s must be marked fast too", t.Name)
+}
+
+type markedStruct struct {
+	Spec     *ast.TypeSpec
+	Options  []string // words after the cgen: binpack mark
+	Template string   // quoted perl pack template after the mark, if any
+}
+
+// collectMarked returns structs marked with a cgen: binpack comment, in the order of declaration
+func collectMarked(node *ast.File) []markedStruct {
+	var res []markedStruct
 	for _, f := range node.Decls {
 		g, ok := f.(*ast.GenDecl)
 		if !ok {
 			fmt.Printf("SKIP %T is not *ast.GenDecl\n", f)
 			continue
 		}
-	SPECS_LOOP:
 		for _, spec := range g.Specs {
 			currType, ok := spec.(*ast.TypeSpec)
 			if !ok {
@@ -65,9 +917,8 @@ func main() {
 				continue
 			}
 
-			currStruct, ok := currType.Type.(*ast.StructType)
-			if !ok {
-				fmt.Printf("SKIP %T is not ast.StructType\n", currStruct)
+			if _, ok := currType.Type.(*ast.StructType); !ok {
+				fmt.Printf("SKIP %T is not ast.StructType\n", currType.Type)
 				continue
 			}
 
@@ -76,53 +927,69 @@ func main() {
 				continue
 			}
 
-			needCodegen := false
+			var mark *ast.Comment
 			for _, comment := range g.Doc.List {
-				needCodegen = needCodegen || strings.HasPrefix(comment.Text, "// cgen: binpack")
+				if strings.HasPrefix(comment.Text, binpackMark) {
+					mark = comment
+				}
 			}
-			if !needCodegen {
+			if mark == nil {
 				fmt.Printf("SKIP struct %#v doesnt have cgen mark\n", currType.Name.Name)
-				continue SPECS_LOOP
+				continue
 			}
 
-			fmt.Printf("process struct %s\n", currType.Name.Name)
-			fmt.Printf("\tgenerating Unpack method\n")
-
-			fmt.Fprintln(out, "func (in *"+currType.Name.Name+") Unpack(data []byte) error {")
-			fmt.Fprintln(out, "	r := bytes.NewReader(data)")
-
-		FIELDS_LOOP:
-			for _, field := range currStruct.Fields.List {
-
-				if field.Tag != nil {
-					tag := reflect.StructTag(field.Tag.Value[1 : len(field.Tag.Value)-1])
-					if tag.Get("cgen") == "-" {
-						continue FIELDS_LOOP
-					}
+			opts, tpl := strings.TrimPrefix(mark.Text, binpackMark), ""
+			if i := strings.IndexByte(opts, '"'); i >= 0 {
+				quoted, err := strconv.QuotedPrefix(opts[i:])
+				if err != nil {
+					log.Fatalf("struct %s: bad pack template in %s", currType.Name.Name, mark.Text)
 				}
+				tpl, _ = strconv.Unquote(quoted)
+				opts = opts[:i] + opts[i+len(quoted):]
+			}
+			res = append(res, markedStruct{currType, strings.Fields(opts), tpl})
+		}
+	}
+	return res
+}
 
-				fieldName := field.Names[0].Name
-				fileType := field.Type.(*ast.Ident).Name
-
-				fmt.Printf("\tgenerating code for field %s.%s\n", currType.Name.Name, fieldName)
+func writeSource(path, pkg string, imports []string, body []byte) {
+	src := &bytes.Buffer{}
+	fmt.Fprintln(src, "// Code generated by binpack codegen; DO NOT EDIT.")
+	fmt.Fprintln(src) // empty line
+	fmt.Fprintln(src, `package `+pkg)
+	fmt.Fprintln(src) // empty line
+	fmt.Fprintln(src, "import (")
+	for _, imp := range imports {
+		fmt.Fprintf(src, "\t%q\n", imp)
+	}
+	fmt.Fprintln(src, ")")
+	src.Write(body)
 
-				switch fileType {
-				case "int":
-					intTpl.Execute(out, tpl{fieldName})
-				case "string":
-					strTpl.Execute(out, tpl{fieldName})
-				default:
-					log.Fatalln("unsupported", fileType)
-				}
-			}
+	formatted, err := format.Source(src.Bytes())
+	if err != nil {
+		log.Fatalf("generated %s is not valid Go: %s", path, err)
+	}
+	if err := ioutil.WriteFile(path, formatted, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
 
-			fmt.Fprintln(out, "	return nil")
-			fmt.Fprintln(out, "}") // end of Unpack func
-			fmt.Fprintln(out)      // empty line
+func (g *generator) importList() []string {
+	res := make([]string, 0, len(g.imports))
+	for imp := range g.imports {
+		res = append(res, imp)
+	}
+	return uniqueSorted(res)
+}
 
+func uniqueSorted(list []string) []string {
+	sort.Strings(list)
+	res := list[:0]
+	for i, s := range list {
+		if i == 0 || s != list[i-1] {
+			res = append(res, s)
 		}
 	}
+	return res
 }
-
-// go build gen/* && ./codegen.exe pack/unpack.go  pack/marshaller.go
-// go run pack/*
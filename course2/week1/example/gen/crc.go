@@ -0,0 +1,61 @@
+package main
+
+import "text/template"
+
+// crcTables are crc32 tables of the crc struct option in the generated code
+var crcTables = map[string]string{
+	"ieee":       "crc32.IEEETable",
+	"castagnoli": "binpackCastagnoli",
+}
+
+// checksums are uint32 in the byte order of the struct, right after the last field they cover
+var crcHelpersTpl = template.Must(template.New("crcHelpersTpl").Parse(`
+var binpackCastagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrChecksum is returned by Unpack when the checksum of Type at Offset does not match the data.
+type ErrChecksum struct {
+	Type     string
+	Offset   int
+	Expected uint32 // read from data
+	Actual   uint32 // of the data
+}
+
+func (e *ErrChecksum) Error() string {
+	return fmt.Sprintf("binpack: %s checksum at offset %d is %08x, data has %08x", e.Type, e.Offset, e.Expected, e.Actual)
+}
+
+// binpackCheckCRC reads the checksum at the offset of r and compares it with the one of data from start up to it.
+func binpackCheckCRC(r *bytes.Reader, order binary.ByteOrder, table *crc32.Table, name string, start int) error {
+	end := binpackOffset(r)
+	var buf [512]byte
+	crc := uint32(0)
+	for off := start; off < end; {
+		n := end - off
+		if n > len(buf) {
+			n = len(buf)
+		}
+		r.ReadAt(buf[:n], int64(off))
+		crc = crc32.Update(crc, table, buf[:n])
+		off += n
+	}
+	var sum uint32
+	if err := binpackRead(r, order, name+".checksum", &sum); err != nil {
+		return err
+	}
+	if sum != crc {
+		return &ErrChecksum{name, end, sum, crc}
+	}
+	return nil
+}
+{{if .}}
+// binpackCRCAt is binpackCheckCRC over data at off, it returns the offset after the checksum.
+func binpackCRCAt(data []byte, off int, order binary.ByteOrder, table *crc32.Table, name string, start int) (int, error) {
+	if len(data)-off < 4 {
+		return 0, &ErrTruncated{name + ".checksum", off}
+	}
+	if sum, crc := order.Uint32(data[off:]), crc32.Checksum(data[start:off], table); sum != crc {
+		return 0, &ErrChecksum{name, off, sum, crc}
+	}
+	return off + 4, nil
+}
+{{end}}`))
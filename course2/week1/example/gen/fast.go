@@ -0,0 +1,276 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+var (
+	fastHelpersTpl = template.Must(template.New("fastHelpersTpl").Parse(`
+// binpackVarintErr returns the error of binary.Uvarint or binary.Varint result n as Unpack reports it,
+// binary.ReadUvarint reports an overflow after 10 bytes even at the end of data.
+func binpackVarintErr(data []byte, off, n int, field string) error {
+	if n == 0 && len(data)-off < binary.MaxVarintLen64 {
+		return &ErrTruncated{field, off}
+	}
+	return fmt.Errorf("binpack: %s at offset %d: binary: varint overflows a 64-bit integer", field, off)
+}
+
+// binpackUvarintAt reads a varint at off and returns it with the offset after it.
+func binpackUvarintAt(data []byte, off int, field string) (uint64, int, error) {
+	v, n := binary.Uvarint(data[off:])
+	if n <= 0 {
+		return 0, 0, binpackVarintErr(data, off, n, field)
+	}
+	return v, off + n, nil
+}
+
+// binpackVarintAt reads a zigzag encoded varint at off and returns it with the offset after it.
+func binpackVarintAt(data []byte, off int, field string) (int64, int, error) {
+	v, n := binary.Varint(data[off:])
+	if n <= 0 {
+		return 0, 0, binpackVarintErr(data, off, n, field)
+	}
+	return v, off + n, nil
+}
+
+// binpackUintAt reads an unsigned integer of size bytes at off, size 0 means a varint.
+// It returns the integer with the offset after it.
+func binpackUintAt(data []byte, off int, order binary.ByteOrder, size int, field string) (uint64, int, error) {
+	if size == 0 {
+		return binpackUvarintAt(data, off, field)
+	}
+	if len(data)-off < size {
+		return 0, 0, &ErrTruncated{field, off}
+	}
+	switch size {
+	case 1:
+		return uint64(data[off]), off + 1, nil
+	case 2:
+		return uint64(order.Uint16(data[off:])), off + 2, nil
+	case 4:
+		return uint64(order.Uint32(data[off:])), off + 4, nil
+	}
+	return order.Uint64(data[off:]), off + 8, nil
+}
+
+// binpackIntAt reads a signed integer of size bytes at off and returns it with the offset after it.
+func binpackIntAt(data []byte, off int, order binary.ByteOrder, size int, field string) (int64, int, error) {
+	v, next, err := binpackUintAt(data, off, order, size, field)
+	switch size {
+	case 1:
+		return int64(int8(v)), next, err
+	case 2:
+		return int64(int16(v)), next, err
+	case 4:
+		return int64(int32(v)), next, err
+	}
+	return int64(v), next, err
+}
+
+// binpackLenAt is binpackReadLen over data at off, it returns the length with the offset after it.
+func binpackLenAt(data []byte, off int, order binary.ByteOrder, size int, field string, max, elemSize int) (int, int, error) {
+	n, next, err := binpackUintAt(data, off, order, size, field)
+	if err != nil {
+		return 0, 0, err
+	}
+	if max > 0 && n > uint64(max) {
+		return 0, 0, &ErrTooLong{field, off, n, max}
+	}
+	if elemSize > 0 && n > uint64((len(data)-next)/elemSize) {
+		return 0, 0, &ErrTruncated{field, len(data)}
+	}
+	return int(n), next, nil
+}
+`))
+
+	unpackFastTpl = template.Must(template.New("unpackFastTpl").Parse(`
+// UnpackFast is Unpack reading data in place by offsets, without a reader and reflection.
+// It returns the same errors as Unpack.{{if .Aliased}} Fields tagged alias share memory with data,
+// data must not be modified while they are used.{{end}}
+func (in *{{.Name}}) UnpackFast(data []byte) error {
+	{{if .Strict}}off, err := in.binpackUnpackFast(data, 0)
+	if err != nil {
+		return err
+	}
+	if off < len(data) {
+		return &ErrTrailingData{"{{.Name}}", off}
+	}
+	return nil{{else}}_, err := in.binpackUnpackFast(data, 0)
+	return err{{end}}
+}
+
+func (in *{{.Name}}) binpackUnpackFast(data []byte, off int) (int, error) {
+{{if .Version}}	version, off, end, err := binpackVersionAt(data, off, {{.Order}}, "{{.Name}}")
+	if err != nil {
+		return 0, err
+	}
+
+{{end}}{{if .CRC}}	start := off
+
+{{end}}{{range .Fields}}	// {{.Name}}
+	{{if gt .Since 1}}if version >= {{.Since}} {
+		{{.DecodeFast}}
+	} else {
+		in.{{.Name}} = {{.Zero}}
+	}{{else}}{{.DecodeFast}}{{end}}
+	{{if eq .Name $.CRCAfter}}
+	// checksum
+	{
+		next, err := binpackCRCAt(data, off, {{$.Order}}, {{$.CRCTable}}, "{{$.Name}}", start)
+		if err != nil {
+			return 0, err
+		}
+		off = next
+	}
+	{{end}}{{if $.Version}}if off > end {
+		return 0, &ErrTruncated{"{{$.Name}}.{{.Name}}", end}
+	}
+	{{end}}
+{{end}}{{if .Version}}	return binpackSkipAt("{{.Name}}", version, {{.Version}}, off, end){{else}}	return off, nil{{end}}
+}
+`))
+)
+
+const fastErr = `err != nil {
+		return 0, err
+	}`
+
+// needBytes returns code reporting data shorter than size bytes at off
+func needBytes(field string, size int) string {
+	return `if len(data)-off < ` + strconv.Itoa(size) + ` {
+	return 0, &ErrTruncated{` + field + `, off}
+}
+`
+}
+
+// decodeFast returns code reading target from data at off and moving off past it.
+// checked means the caller has already checked that data holds a fixed type.
+func (g *generator) decodeFast(t *binType, target, path string, max, depth int, checked bool) string {
+	field := strconv.Quote(path)
+	switch t.Kind {
+	case "string", "bytes":
+		n, next := v("n", depth), v("next", depth)
+		end := next + ` + ` + n
+		res := `{
+	` + n + `, ` + next + `, err := binpackLenAt(data, off, ` + lenArgs(t) + `, ` + field + `, ` + strconv.Itoa(max) + `, 1)
+	if ` + fastErr + `
+	`
+		switch {
+		case t.Kind == "string" && t.Alias:
+			g.imports["unsafe"] = true
+			res += target + ` = ""
+	if ` + n + ` > 0 {
+		` + target + ` = unsafe.String(&data[` + next + `], ` + n + `)
+	}`
+		case t.Kind == "string":
+			res += target + ` = string(data[` + next + `:` + end + `])`
+		case t.Alias:
+			res += target + ` = nil
+	if ` + n + ` > 0 {
+		` + target + ` = data[` + next + `:` + end + `:` + end + `]
+	}`
+		default:
+			res += target + ` = nil
+	if ` + n + ` > 0 {
+		` + target + ` = make(` + t.Name + `, ` + n + `)
+		copy(` + target + `, data[` + next + `:])
+	}`
+		}
+		return res + `
+	off = ` + end + `
+}`
+	case "marshaler":
+		n, next := v("n", depth), v("next", depth)
+		end := next + ` + ` + n
+		return `{
+	` + n + `, ` + next + `, err := binpackLenAt(data, off, ` + lenArgs(t) + `, ` + field + `, ` + strconv.Itoa(max) + `, 1)
+	if ` + fastErr + `
+	if err := ` + target + `.UnmarshalBinary(data[` + next + `:` + end + `:` + end + `]); err != nil {
+		return 0, fmt.Errorf("` + path + `: %s", err)
+	}
+	off = ` + end + `
+}`
+	case "array":
+		res := ""
+		if t.Fixed() && !checked {
+			// checked at once, as binary.Read of Unpack reports the array start
+			res = needBytes(field, t.MinSize(g.structs))
+			checked = true
+		}
+		if t.Elem.Kind == "uint8" && checked {
+			return res + `copy(` + target + `[:], data[off:])
+off += ` + strconv.Itoa(t.Len)
+		}
+		i := v("i", depth)
+		return res + `for ` + i + ` := range ` + target + ` {
+	` + unblock(g.decodeFast(t.Elem, target+"["+i+"]", path, 0, depth+1, checked)) + `
+}`
+	case "slice":
+		n, next, i := v("n", depth), v("next", depth), v("i", depth)
+		// binpackLenAt checks data holds n elements of the min size, which is exact for fixed ones
+		return `{
+	` + n + `, ` + next + `, err := binpackLenAt(data, off, ` + lenArgs(t) + `, ` + field + `, ` + strconv.Itoa(max) + `, ` + strconv.Itoa(t.Elem.MinSize(g.structs)) + `)
+	if ` + fastErr + `
+	off = ` + next + `
+	` + target + ` = nil
+	if ` + n + ` > 0 {
+		` + target + ` = make(` + t.Name + `, ` + n + `)
+		for ` + i + ` := range ` + target + ` {
+			` + unblock(g.decodeFast(t.Elem, target+"["+i+"]", path, 0, depth+1, t.Elem.Fixed())) + `
+		}
+	}
+}`
+	case "struct":
+		next := v("next", depth)
+		return `{
+	` + next + `, err := ` + target + `.binpackUnpackFast(data, off)
+	if ` + fastErr + `
+	off = ` + next + `
+}`
+	}
+
+	if t.IsInt() && !t.Fixed() {
+		x, next := v("x", depth), v("next", depth)
+		wireSigned, _ := t.wireRange()
+		read := ""
+		switch {
+		case t.Wire == "varint" && wireSigned:
+			read = `binpackVarintAt(data, off, ` + field + `)`
+		case t.Wire == "varint":
+			read = `binpackUvarintAt(data, off, ` + field + `)`
+		case wireSigned:
+			read = `binpackIntAt(data, off, ` + t.Order + `, ` + strconv.Itoa(wireSizes[t.Wire]) + `, ` + field + `)`
+		default:
+			read = `binpackUintAt(data, off, ` + t.Order + `, ` + strconv.Itoa(wireSizes[t.Wire]) + `, ` + field + `)`
+		}
+		return `{
+	` + x + `, ` + next + `, err := ` + read + `
+	if ` + fastErr + intOverflow(t, x, path, "return 0, ") + `
+	` + target + ` = ` + t.Name + `(` + x + `)
+	off = ` + next + `
+}`
+	}
+
+	size := scalarSizes[t.Kind]
+	bits := strconv.Itoa(8 * size)
+	value := ""
+	switch {
+	case t.Kind == "bool":
+		value = `data[off] != 0`
+	case size == 1:
+		value = t.Kind + `(data[off])`
+	case strings.HasPrefix(t.Kind, "float"):
+		g.imports["math"] = true
+		value = `math.Float` + bits + `frombits(` + t.Order + `.Uint` + bits + `(data[off:]))`
+	default:
+		value = t.Kind + `(` + t.Order + `.Uint` + bits + `(data[off:]))`
+	}
+	res := ""
+	if !checked {
+		res = needBytes(field, size)
+	}
+	return res + target + ` = ` + value + `
+off += ` + strconv.Itoa(size)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// fuzzTpl is a native fuzz target of Unpack. Seeds are packed zero, sample and extreme
+// values of the struct and a truncated sample.
+var fuzzTpl = template.Must(template.New("fuzzTpl").Parse(`
+func binpackExtreme{{.Name}}() {{.Name}} {
+	return {{.Name}}{
+		{{range .Fields}}{{.Name}}: {{.Extreme}},
+		{{end}}}
+}
+
+func FuzzUnpack{{.Name}}(f *testing.F) {
+	for _, in := range []{{.Name}}{ {}, binpackSample{{.Name}}(), binpackExtreme{{.Name}}() } {
+		data, err := in.Pack()
+		if err != nil {
+			f.Fatalf("Pack of seed %#v: %s", in, err)
+		}
+		f.Add(data)
+		f.Add(data[:len(data)/2])
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		out := {{.Name}}{}
+		err := out.Unpack(data)
+		{{if .Fast}}fast := {{.Name}}{}
+		if fastErr := fast.UnpackFast(data); fmt.Sprint(fastErr) != fmt.Sprint(err) {
+			t.Fatalf("UnpackFast: got %v, expected %v", fastErr, err)
+		}
+		{{end}}if err != nil {
+			return
+		}
+
+		// compared packed, as NaN floats are not equal to themselves
+		packed, err := out.Pack()
+		if err != nil {
+			t.Fatalf("Pack of unpacked %#v: %s", out, err)
+		}
+		if size := out.PackedSize(); size != len(packed) {
+			t.Fatalf("PackedSize = %d, packed %d bytes", size, len(packed))
+		}
+		{{if .Fast}}if fastPacked := fast.AppendPack(nil); !bytes.Equal(fastPacked, packed) {
+			t.Fatalf("UnpackFast result packs to %v, Unpack one to %v", fastPacked, packed)
+		}
+		{{end}}again := {{.Name}}{}
+		if err := again.Unpack(packed); err != nil {
+			t.Fatalf("Unpack of packed %#v: %s", out, err)
+		}
+		if repacked := again.AppendPack(nil); !bytes.Equal(repacked, packed) {
+			t.Fatalf("round trip mismatch\npacked:   %v\nrepacked: %v", packed, repacked)
+		}
+	})
+}
+`))
+
+// extremeInt returns a Go constant of the integer which is the farthest from zero
+// and fits both the Go type and the wire, negative if both are signed
+func extremeInt(t *binType) string {
+	goSigned, goBits := t.goRange(32)
+	wireSigned, wireBits := t.wireRange()
+	bits := goBits
+	if wireBits < bits {
+		bits = wireBits
+	}
+	if goSigned && wireSigned {
+		return "math.MinInt" + strconv.Itoa(bits)
+	}
+	// the max of a signed range is the one of an unsigned range a bit narrower
+	exp := 64
+	for _, r := range []struct {
+		signed bool
+		bits   int
+	}{{goSigned, goBits}, {wireSigned, wireBits}} {
+		e := r.bits
+		if r.signed {
+			e--
+		}
+		if e < exp {
+			exp = e
+		}
+	}
+	if exp%8 == 0 {
+		return "math.MaxUint" + strconv.Itoa(exp)
+	}
+	return "math.MaxInt" + strconv.Itoa(exp+1)
+}
+
+// extreme returns a Go literal of the type with values at the edges of their ranges,
+// strings as long as max allows
+func (g *generator) extreme(t *binType, max, depth int) string {
+	switch t.Kind {
+	case "bool":
+		return "true"
+	case "float32":
+		return "math.MaxFloat32"
+	case "float64":
+		return "-math.MaxFloat64"
+	case "string":
+		if max > 0 {
+			return `strings.Repeat("\xff", ` + strconv.Itoa(max) + `)`
+		}
+		return `"\xff"`
+	case "bytes":
+		if max > 0 {
+			return `bytes.Repeat([]byte{0xff}, ` + strconv.Itoa(max) + `)`
+		}
+		return t.Name + `{0xff}`
+	case "marshaler":
+		return zeroValue(t)
+	case "array", "slice":
+		if t.Len == 0 && t.Kind == "array" {
+			return t.Name + "{}"
+		}
+		if t.Kind == "slice" && depth > 2 {
+			// recursive types end here
+			return "nil"
+		}
+		return t.Name + "{" + g.extreme(t.Elem, 0, depth+1) + "}"
+	case "struct":
+		st := g.structs[t.Name]
+		fields := make([]string, len(st.Fields))
+		for i, f := range st.Fields {
+			fields[i] = f.Name + ": " + g.extreme(f.Type, f.Max, depth+1)
+		}
+		return t.Name + "{" + strings.Join(fields, ", ") + "}"
+	}
+	return extremeInt(t)
+}
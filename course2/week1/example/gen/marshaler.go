@@ -0,0 +1,190 @@
+package main
+
+import (
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path"
+	"strconv"
+	"text/template"
+)
+
+// Generated structs implement encoding.BinaryMarshaler and encoding.BinaryUnmarshaler with Pack
+// and Unpack, so they are packed by encoding/gob and others as they are. Fields of types which
+// implement both of them, like time.Time, are packed as []byte of MarshalBinary.
+
+var (
+	sizeHelpersTpl = template.Must(template.New("sizeHelpersTpl").Parse(`
+// binpackUvarintSize returns the number of bytes binary.AppendUvarint appends for x.
+func binpackUvarintSize(x uint64) int {
+	n := 1
+	for ; x >= 0x80; x >>= 7 {
+		n++
+	}
+	return n
+}
+
+// binpackVarintSize returns the number of bytes binary.AppendVarint appends for x.
+func binpackVarintSize(x int64) int {
+	return binpackUvarintSize(uint64(x)<<1 ^ uint64(x>>63))
+}
+`))
+
+	marshalerTpl = template.Must(template.New("marshalerTpl").Parse(`
+// PackedSize returns the length of the binary representation of in, Pack allocates exactly it.
+func (in *{{.Name}}) PackedSize() int {
+	size := {{.FixedSize}}
+{{range .Fields}}{{if .Size}}	// {{.Name}}
+	{{.Size}}
+{{end}}{{end}}	return size
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler with Pack.
+func (in *{{.Name}}) MarshalBinary() ([]byte, error) {
+	return in.Pack()
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler with Unpack,
+// which does not keep data, callers may reuse it.
+func (in *{{.Name}}) UnmarshalBinary(data []byte) error {
+	return in.Unpack(data)
+}
+`))
+)
+
+// typeCheck returns types of the expressions of the file, types it can not resolve are left out
+func typeCheck(fset *token.FileSet, node *ast.File) *types.Info {
+	info := &types.Info{Types: make(map[ast.Expr]types.TypeAndValue)}
+	conf := types.Config{Importer: importer.Default(), Error: func(error) {}}
+	conf.Check(node.Name.Name, fset, []*ast.File{node}, info)
+	return info
+}
+
+// binaryMarshaler is encoding.BinaryMarshaler and encoding.BinaryUnmarshaler together
+var binaryMarshaler = func() *types.Interface {
+	bytes := types.NewVar(token.NoPos, nil, "", types.NewSlice(types.Typ[types.Byte]))
+	err := types.NewVar(token.NoPos, nil, "", types.Universe.Lookup("error").Type())
+	marshal := types.NewSignatureType(nil, nil, nil, nil, types.NewTuple(bytes, err), false)
+	unmarshal := types.NewSignatureType(nil, nil, nil, types.NewTuple(bytes), types.NewTuple(err), false)
+	return types.NewInterfaceType([]*types.Func{
+		types.NewFunc(token.NoPos, nil, "MarshalBinary", marshal),
+		types.NewFunc(token.NoPos, nil, "UnmarshalBinary", unmarshal),
+	}, nil).Complete()
+}()
+
+// isBinaryMarshaler reports whether a pointer to the type implements binaryMarshaler
+func isBinaryMarshaler(info *types.Info, expr ast.Expr) bool {
+	tv, ok := info.Types[expr]
+	if !ok || tv.Type == nil {
+		return false
+	}
+	return types.Implements(types.NewPointer(tv.Type), binaryMarshaler)
+}
+
+// fileImports returns import paths of the file by their package names
+func fileImports(node *ast.File) map[string]string {
+	res := make(map[string]string, len(node.Imports))
+	for _, imp := range node.Imports {
+		p, _ := strconv.Unquote(imp.Path.Value)
+		name := path.Base(p)
+		if imp.Name != nil {
+			name = imp.Name.Name
+		}
+		res[name] = p
+	}
+	return res
+}
+
+// usedImports returns imports of the source file the generated code refers to,
+// like packages of marshaler fields
+func usedImports(body []byte, imports map[string]string) []string {
+	f, err := parser.ParseFile(token.NewFileSet(), "", append([]byte("package p\n"), body...), 0)
+	if err != nil {
+		// writeSource reports it
+		return nil
+	}
+	var res []string
+	for _, id := range f.Unresolved {
+		if p, ok := imports[id.Name]; ok {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+// constSize returns the size of the type on the wire if all its values take the same
+func (g *generator) constSize(t *binType) (int, bool) {
+	switch t.Kind {
+	case "string", "bytes", "slice", "marshaler":
+		return 0, false
+	case "array":
+		n, ok := g.constSize(t.Elem)
+		return t.Len * n, ok
+	case "struct":
+		st, res := g.structs[t.Name], 0
+		if st.Version > 0 {
+			res = 6
+		}
+		if st.CRC != "" {
+			res += 4
+		}
+		for _, f := range st.Fields {
+			n, ok := g.constSize(f.Type)
+			if !ok {
+				return 0, false
+			}
+			res += n
+		}
+		return res, true
+	}
+	if t.IsInt() {
+		return wireSizes[t.Wire], t.Wire != "varint"
+	}
+	return scalarSizes[t.Kind], true
+}
+
+// prefixLen returns a Go expression of the size of the length prefix of n
+func prefixLen(t *binType, n string) string {
+	if t.Prefix == "varint" {
+		return `binpackUvarintSize(uint64(` + n + `))`
+	}
+	return strconv.Itoa(wireSizes[t.Prefix])
+}
+
+// size returns code adding the size of target on the wire to size
+func (g *generator) size(t *binType, target string, depth int) string {
+	if n, ok := g.constSize(t); ok {
+		return `size += ` + strconv.Itoa(n)
+	}
+	switch t.Kind {
+	case "string", "bytes":
+		return `size += ` + prefixLen(t, `len(`+target+`)`) + ` + len(` + target + `)`
+	case "marshaler":
+		b := v("b", depth)
+		return `{
+	` + b + `, _ := ` + target + `.MarshalBinary()
+	size += ` + prefixLen(t, `len(`+b+`)`) + ` + len(` + b + `)
+}`
+	case "array", "slice":
+		res := ""
+		if t.Kind == "slice" {
+			res = `size += ` + prefixLen(t, `len(`+target+`)`)
+			if n, ok := g.constSize(t.Elem); ok {
+				return res + ` + ` + strconv.Itoa(n) + `*len(` + target + `)`
+			}
+			res += "\n"
+		}
+		i := v("i", depth)
+		return res + `for ` + i + ` := range ` + target + ` {
+	` + unblock(g.size(t.Elem, target+"["+i+"]", depth+1)) + `
+}`
+	case "struct":
+		return `size += ` + target + `.PackedSize()`
+	}
+	if t.Signed() {
+		return `size += binpackVarintSize(int64(` + target + `))`
+	}
+	return `size += binpackUvarintSize(uint64(` + target + `))`
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Perl pack templates describe the layout of a struct, like the one the example data is made with:
+//
+//	perl -E 'print pack("L L/a* L", 1_123_456, "v.romanov", 16)'
+//
+// Templates are compared by their layout, so "L L/a* L" matches "V V/a* V" generated for User.
+// Native letters (s, S, l, L, q, Q, i, I, j, J, f, d) are taken as little endian, as on x86.
+// Varints of Go are LEB128 and perl w is BER, so fields with varints have no template,
+// and neither have versioned records, their length prefix covers all the fields.
+// Checksums are integer letters, the perl side has to compute them itself.
+
+// perlInts are integer letters with their default atoms, < and > change the order of wider ones
+var perlInts = map[byte]string{
+	'c': "i8", 'C': "u8",
+	's': "i16le", 'S': "u16le",
+	'l': "i32le", 'L': "u32le",
+	'i': "i32le", 'I': "u32le",
+	'q': "i64le", 'Q': "u64le",
+	'j': "i64le", 'J': "u64le",
+	'n': "u16be", 'N': "u32be",
+	'v': "u16le", 'V': "u32le",
+	'f': "f32le", 'd': "f64le",
+}
+
+// perlLetters are letters of the generated templates by atom
+var perlLetters = map[string]string{
+	"i8": "c", "u8": "C",
+	"i16le": "s<", "i16be": "s>", "u16le": "v", "u16be": "n",
+	"i32le": "l<", "i32be": "l>", "u32le": "V", "u32be": "N",
+	"i64le": "q<", "i64be": "q>", "u64le": "Q<", "u64be": "Q>",
+	"f32le": "f<", "f32be": "f>", "f64le": "d<", "f64be": "d>",
+}
+
+// wireAtom returns the atom of an integer wire or a float kind in the byte order
+func wireAtom(wire, order string) string {
+	atom := wire
+	if wire[0] == 'f' {
+		atom = "f" + strings.TrimPrefix(wire, "float")
+	}
+	if wireSizes[wire] == 1 {
+		return atom
+	}
+	if order == byteOrders["be"] {
+		return atom + "be"
+	}
+	return atom + "le"
+}
+
+// packTemplate returns the perl pack template of the struct layout
+func (g *generator) packTemplate(st *binStruct) (string, error) {
+	if st.Version > 0 {
+		return "", fmt.Errorf("versioned records have no pack template")
+	}
+	res := make([]string, 0, len(st.Fields)+1)
+	for _, f := range st.Fields {
+		tpl, err := g.typeTemplate(f.Type)
+		if err != nil {
+			return "", fmt.Errorf("%s: %s", f.Name, err)
+		}
+		res = append(res, tpl)
+		if f.Name == st.CRCAfter {
+			res = append(res, perlLetters[wireAtom("u32", st.Order)])
+		}
+	}
+	return strings.Join(res, " "), nil
+}
+
+func (g *generator) typeTemplate(t *binType) (string, error) {
+	switch t.Kind {
+	case "bool":
+		return "C", nil
+	case "float32", "float64":
+		return perlLetters[wireAtom(t.Kind, t.Order)], nil
+	case "string", "bytes", "slice", "marshaler":
+		if t.Prefix == "varint" {
+			return "", fmt.Errorf("varint lengths have no pack template, perl w is not a Go varint")
+		}
+		prefix := perlLetters[wireAtom(t.Prefix, t.Order)]
+		if t.Kind != "slice" {
+			return prefix + "/a*", nil
+		}
+		elem, err := g.typeTemplate(t.Elem)
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case len(elem) == 1 || len(elem) == 2 && strings.ContainsAny(elem[1:], "<>"):
+			return prefix + "/" + elem + "*", nil
+		case t.Elem.Kind == "struct":
+			return prefix + "/" + elem, nil
+		}
+		return prefix + "/(" + elem + ")", nil
+	case "array":
+		if t.Elem.Kind == "uint8" && t.Elem.Wire == "u8" {
+			return "a" + strconv.Itoa(t.Len), nil
+		}
+		elem, err := g.typeTemplate(t.Elem)
+		if err != nil {
+			return "", err
+		}
+		if !strings.ContainsAny(elem, " /(") || t.Elem.Kind == "struct" {
+			return elem + strconv.Itoa(t.Len), nil
+		}
+		return "(" + elem + ")" + strconv.Itoa(t.Len), nil
+	case "struct":
+		tpl, err := g.packTemplate(g.structs[t.Name])
+		if err != nil {
+			return "", fmt.Errorf("%s: %s", t.Name, err)
+		}
+		return "(" + tpl + ")", nil
+	}
+	if t.Wire == "varint" {
+		return "", fmt.Errorf("varints have no pack template, perl w is not a Go varint")
+	}
+	return perlLetters[wireAtom(t.Wire, t.Order)], nil
+}
+
+// normalizeTemplate parses a perl pack template and returns its layout as a list of atoms,
+// groups and repeat counts are expanded, length prefixed items are kept as "len/(items)".
+func normalizeTemplate(tpl string) ([]string, error) {
+	p := &perlParser{src: tpl}
+	res, err := p.items()
+	if err == nil && p.pos < len(p.src) {
+		err = fmt.Errorf("unexpected %q", p.src[p.pos])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("bad pack template %q at %d: %s", tpl, p.pos, err)
+	}
+	return res, nil
+}
+
+type perlParser struct {
+	src string
+	pos int
+}
+
+func (p *perlParser) peek() byte {
+	for p.pos < len(p.src) && p.src[p.pos] == ' ' {
+		p.pos++
+	}
+	if p.pos == len(p.src) {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+// items parses items up to the end or a closing parenthesis
+func (p *perlParser) items() ([]string, error) {
+	var res []string
+	for c := p.peek(); c != 0 && c != ')'; c = p.peek() {
+		atoms, letter, err := p.item(false)
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != '/' {
+			res = append(res, atoms...)
+			continue
+		}
+		// length-item/sequence-item
+		p.pos++
+		if len(atoms) != 1 || atoms[0][0] != 'u' {
+			return nil, fmt.Errorf("length of %q must be a single unsigned integer", letter)
+		}
+		seq, err := p.sequence()
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, atoms[0]+"/"+seq)
+	}
+	return res, nil
+}
+
+// item parses a letter or a group with modifiers and a count, it returns the expanded atoms.
+// seq allows the * count of an item after a length, which repeats it length times.
+func (p *perlParser) item(seq bool) ([]string, string, error) {
+	c := p.peek()
+	var atoms []string
+	letter := string(c)
+	switch {
+	case c == '(':
+		p.pos++
+		inner, err := p.items()
+		if err != nil {
+			return nil, "", err
+		}
+		if p.peek() != ')' {
+			return nil, "", fmt.Errorf("unclosed group")
+		}
+		p.pos++
+		atoms = inner
+	case c == 'a':
+		p.pos++
+		atoms = []string{"u8"}
+	case perlInts[c] != "":
+		p.pos++
+		atom := perlInts[c]
+		for p.pos < len(p.src) && strings.IndexByte("<>!", p.src[p.pos]) >= 0 {
+			m := p.src[p.pos]
+			p.pos++
+			switch {
+			case m == '!' && strings.IndexByte("nNvV", c) >= 0:
+				atom = "i" + atom[1:]
+			case m == '!':
+				return nil, "", fmt.Errorf("! of %q has a platform dependent size", c)
+			case len(atom) > 2 && strings.IndexByte("nNvV", c) < 0:
+				atom = atom[:len(atom)-2] + map[byte]string{'<': "le", '>': "be"}[m]
+			default:
+				return nil, "", fmt.Errorf("%q does not take %q", c, m)
+			}
+		}
+		atoms = []string{atom}
+	default:
+		return nil, "", fmt.Errorf("unsupported letter %q", c)
+	}
+
+	// count
+	start := p.pos
+	for p.pos < len(p.src) && p.src[p.pos] >= '0' && p.src[p.pos] <= '9' {
+		p.pos++
+	}
+	if p.pos > start {
+		n, _ := strconv.Atoi(p.src[start:p.pos])
+		res := make([]string, 0, n*len(atoms))
+		for i := 0; i < n; i++ {
+			res = append(res, atoms...)
+		}
+		return res, letter, nil
+	}
+	if p.pos < len(p.src) && p.src[p.pos] == '*' {
+		if !seq {
+			return nil, "", fmt.Errorf("* of %q is supported only after a length", letter)
+		}
+		p.pos++
+	}
+	return atoms, letter, nil
+}
+
+// sequence parses the item after a length, which is a* or repeated items
+func (p *perlParser) sequence() (string, error) {
+	p.peek()
+	if strings.HasPrefix(p.src[p.pos:], "a*") {
+		p.pos += 2
+		return "a*", nil
+	}
+	atoms, letter, err := p.item(true)
+	if err != nil {
+		return "", err
+	}
+	if letter == "a" || len(atoms) == 0 {
+		return "", fmt.Errorf("bad sequence after a length")
+	}
+	if len(atoms) == 1 && atoms[0] == "u8" {
+		// C* after a length is laid out as a*
+		return "a*", nil
+	}
+	return "(" + strings.Join(atoms, " ") + ")", nil
+}
+
+// checkTemplate reports a template which does not match the layout of the struct
+func (g *generator) checkTemplate(st *binStruct, tpl string) (string, error) {
+	expected, err := g.packTemplate(st)
+	if err != nil {
+		return "", err
+	}
+	got, err := normalizeTemplate(tpl)
+	if err != nil {
+		return "", err
+	}
+	want, err := normalizeTemplate(expected)
+	if err != nil {
+		return "", err
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		return "", fmt.Errorf("pack template %q does not match the fields, expected %q", tpl, expected)
+	}
+	return expected, nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import "text/template"
+
+var (
+	// frames are the packed length as little endian uint32 followed by the packed struct
+	streamHelpersTpl = template.Must(template.New("streamHelpersTpl").Parse(`
+// binpackRecord is implemented by every struct marked cgen: binpack.
+type binpackRecord interface {
+	Unpack(data []byte) error
+	AppendPack(dst []byte) []byte
+	binpackCheck() error
+}
+
+// binpackReadFrame reads a frame from r into buf and returns its data with the number of bytes read.
+// The data is copied as it arrives, so a broken length does not allocate more than r holds.
+func binpackReadFrame(r io.Reader, buf *bytes.Buffer, max, off int) ([]byte, int64, error) {
+	var hdr [4]byte
+	n, err := io.ReadFull(r, hdr[:])
+	if err != nil {
+		return nil, int64(n), err
+	}
+	size := binary.LittleEndian.Uint32(hdr[:])
+	if max > 0 && uint64(size) > uint64(max) {
+		return nil, int64(n), &ErrTooLong{"frame", off, uint64(size), max}
+	}
+	buf.Reset()
+	m, err := io.CopyN(buf, r, int64(size))
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return buf.Bytes(), int64(n) + m, err
+}
+
+// binpackWriteFrame packs rec as a frame into buf and writes it to w.
+func binpackWriteFrame(w io.Writer, buf []byte, rec binpackRecord) ([]byte, int64, error) {
+	if err := rec.binpackCheck(); err != nil {
+		return buf, 0, err
+	}
+	buf = rec.AppendPack(append(buf[:0], 0, 0, 0, 0))
+	if uint64(len(buf)-4) > 1<<32-1 {
+		return buf, 0, fmt.Errorf("binpack: frame of %d bytes does not fit uint32 length", len(buf)-4)
+	}
+	binary.LittleEndian.PutUint32(buf, uint32(len(buf)-4))
+	n, err := w.Write(buf)
+	return buf, int64(n), err
+}
+
+// FrameReader reads records written by FrameWriter or WriteTo from a stream.
+// It reads no further than the current frame, so r can be passed on to ReadFrom.
+type FrameReader struct {
+	r   io.Reader
+	max int
+	off int
+	buf bytes.Buffer
+}
+
+// NewFrameReader returns a FrameReader of r which rejects frames longer than max bytes
+// with *ErrTooLong, 0 means no limit.
+func NewFrameReader(r io.Reader, max int) *FrameReader {
+	return &FrameReader{r: r, max: max}
+}
+
+// Next reads the next frame and unpacks it into rec. It returns io.EOF when the stream
+// ends between frames and io.ErrUnexpectedEOF when it ends inside one.
+func (fr *FrameReader) Next(rec binpackRecord) error {
+	data, n, err := binpackReadFrame(fr.r, &fr.buf, fr.max, fr.off)
+	fr.off += int(n)
+	if err != nil {
+		return err
+	}
+	return rec.Unpack(data)
+}
+
+// FrameWriter writes records to a stream as frames, each is the packed length as
+// little endian uint32 followed by the packed record.
+type FrameWriter struct {
+	w   io.Writer
+	buf []byte
+}
+
+// NewFrameWriter returns a FrameWriter of w.
+func NewFrameWriter(w io.Writer) *FrameWriter {
+	return &FrameWriter{w: w}
+}
+
+// Write packs rec and writes it as a frame with a single Write call.
+func (fw *FrameWriter) Write(rec binpackRecord) error {
+	var err error
+	fw.buf, _, err = binpackWriteFrame(fw.w, fw.buf, rec)
+	return err
+}
+`))
+
+	streamTpl = template.Must(template.New("streamTpl").Parse(`
+// ReadFrom reads a single frame written by WriteTo or FrameWriter from r and unpacks it.
+// It returns io.EOF if r ends before the frame and io.ErrUnexpectedEOF if r ends inside it.
+func (in *{{.Name}}) ReadFrom(r io.Reader) (int64, error) {
+	data, n, err := binpackReadFrame(r, &bytes.Buffer{}, 0, 0)
+	if err != nil {
+		return n, err
+	}
+	return n, in.Unpack(data)
+}
+
+// WriteTo writes in to w as a frame, see FrameWriter.
+func (in *{{.Name}}) WriteTo(w io.Writer) (int64, error) {
+	_, n, err := binpackWriteFrame(w, nil, in)
+	return n, err
+}
+`))
+)
@@ -0,0 +1,610 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strconv"
+	"strings"
+)
+
+// binType describes how a Go type is laid out on the wire:
+//
+//	int8 ... int64, uint8 ... uint64   fixed width
+//	int, uint                          as uint32, like perl's L
+//	float32, float64                   IEEE 754 bits
+//	bool                               one byte, 0 or 1
+//	string, []byte                     length, then bytes
+//	[N]T                               N elements, no length
+//	[]T                                count of elements, then elements
+//	T                                  fields of a struct marked cgen: binpack
+//	encoding.BinaryMarshaler           length, then MarshalBinary bytes
+//
+// By default numbers and lengths are little endian and lengths are uint32,
+// cgen tags change it per field, see parseFieldTag.
+type binType struct {
+	Kind   string // scalar type name, "string", "bytes", "array", "slice", "struct" or "marshaler"
+	Name   string // Go type expression
+	Len    int    // number of elements of an array
+	Elem   *binType
+	Order  string // binary.LittleEndian or binary.BigEndian
+	Wire   string // integers: "u8" ... "u64", "i8" ... "i64" or "varint"
+	Prefix string // strings, []byte, slices and marshalers: length "u8" ... "u64" or "varint"
+	Alias  bool   // strings and []byte decoded by UnpackFast share memory with data
+}
+
+var scalarSizes = map[string]int{
+	"int8": 1, "int16": 2, "int32": 4, "int64": 8, "int": 8,
+	"uint8": 1, "uint16": 2, "uint32": 4, "uint64": 8, "uint": 8,
+	"float32": 4, "float64": 8,
+	"bool": 1,
+}
+
+// wireSizes are sizes of fixed width integers and length prefixes, varint is not here
+var wireSizes = map[string]int{
+	"u8": 1, "u16": 2, "u32": 4, "u64": 8,
+	"i8": 1, "i16": 2, "i32": 4, "i64": 8,
+}
+
+func parseType(expr ast.Expr, marked map[string]bool, info *types.Info) (*binType, error) {
+	switch t := expr.(type) {
+	case *ast.Ident:
+		name := t.Name
+		switch name {
+		case "byte":
+			name = "uint8"
+		case "rune":
+			name = "int32"
+		}
+		if _, ok := scalarSizes[name]; ok {
+			return &binType{Kind: name, Name: t.Name}, nil
+		}
+		if name == "string" {
+			return &binType{Kind: "string", Name: name}, nil
+		}
+		if marked[name] {
+			return &binType{Kind: "struct", Name: name}, nil
+		}
+		if isBinaryMarshaler(info, t) {
+			return &binType{Kind: "marshaler", Name: name}, nil
+		}
+		return nil, fmt.Errorf("unsupported type %s, only basic types, structs marked cgen: binpack and encoding.BinaryMarshaler can be packed", name)
+
+	case *ast.SelectorExpr:
+		if isBinaryMarshaler(info, t) {
+			return &binType{Kind: "marshaler", Name: types.ExprString(t)}, nil
+		}
+
+	case *ast.ArrayType:
+		elem, err := parseType(t.Elt, marked, info)
+		if err != nil {
+			return nil, err
+		}
+		name := types.ExprString(t)
+		if t.Len == nil {
+			if elem.Kind == "uint8" {
+				return &binType{Kind: "bytes", Name: name, Elem: elem}, nil
+			}
+			return &binType{Kind: "slice", Name: name, Elem: elem}, nil
+		}
+		lit, ok := t.Len.(*ast.BasicLit)
+		if !ok || lit.Kind != token.INT {
+			return nil, fmt.Errorf("array length of %s must be an integer literal", name)
+		}
+		n, err := strconv.Atoi(lit.Value)
+		if err != nil {
+			return nil, fmt.Errorf("bad array length of %s: %s", name, err)
+		}
+		return &binType{Kind: "array", Name: name, Len: n, Elem: elem}, nil
+	}
+	return nil, fmt.Errorf("unsupported type %s", types.ExprString(expr))
+}
+
+// IsInt reports whether the type is an integer.
+func (t *binType) IsInt() bool {
+	return strings.HasPrefix(t.Kind, "int") || strings.HasPrefix(t.Kind, "uint")
+}
+
+// Signed reports whether the Go type is a signed integer.
+func (t *binType) Signed() bool {
+	return strings.HasPrefix(t.Kind, "int")
+}
+
+// naturalWire returns the wire of an integer which is the same as its Go type
+func (t *binType) naturalWire() string {
+	bits := strconv.Itoa(8 * scalarSizes[t.Kind])
+	if t.Signed() {
+		return "i" + bits
+	}
+	return "u" + bits
+}
+
+// defaultWire returns the wire of an integer without a width tag. int and uint
+// are uint32 whatever their Go width is, so the layout does not depend on the platform.
+func (t *binType) defaultWire() string {
+	if t.Kind == "int" || t.Kind == "uint" {
+		return "u32"
+	}
+	return t.naturalWire()
+}
+
+// applyOptions sets the byte order, the integer wire and the length prefix of the
+// type and its elements. Length options apply only to the outermost length.
+func (t *binType) applyOptions(order, wire, prefix string) error {
+	t.Order = order
+	switch {
+	case t.IsInt():
+		t.Wire = t.defaultWire()
+		if wire != "" {
+			t.Wire = wire
+		}
+		return nil
+	case t.Kind == "string" || t.Kind == "bytes" || t.Kind == "slice" || t.Kind == "marshaler":
+		t.Prefix = "u32"
+		if prefix != "" {
+			t.Prefix = prefix
+		}
+	case prefix != "":
+		return fmt.Errorf("len is supported only for strings, []byte, slices and marshalers")
+	}
+	switch t.Kind {
+	case "array", "slice":
+		return t.Elem.applyOptions(order, wire, "")
+	}
+	if wire != "" {
+		return fmt.Errorf("%s is supported only for integers and their arrays and slices", wire)
+	}
+	return nil
+}
+
+// setAlias makes UnpackFast alias strings and []byte of the type to data.
+func (t *binType) setAlias() error {
+	switch t.Kind {
+	case "string", "bytes":
+		t.Alias = true
+		return nil
+	case "array", "slice":
+		return t.Elem.setAlias()
+	}
+	return fmt.Errorf("alias is supported only for strings, []byte and their arrays and slices")
+}
+
+// Fixed reports whether values of the type are read with a single binary.Read call.
+// int and uint are not, their width differs from the Go one.
+func (t *binType) Fixed() bool {
+	switch t.Kind {
+	case "int", "uint", "string", "bytes", "slice", "struct", "marshaler":
+		return false
+	case "array":
+		return t.Elem.Fixed()
+	}
+	return !t.IsInt() || t.Wire == t.naturalWire()
+}
+
+// MinSize returns the least number of bytes a value of the type takes on the wire.
+func (t *binType) MinSize(structs map[string]*binStruct) int {
+	switch t.Kind {
+	case "string", "bytes", "slice", "marshaler":
+		return prefixSize(t.Prefix)
+	case "array":
+		return t.Len * t.Elem.MinSize(structs)
+	case "struct":
+		st, res := structs[t.Name], 0
+		if st.Version > 0 {
+			res = 6 // version header
+		}
+		if st.CRC != "" {
+			res += 4
+		}
+		for _, f := range st.Fields {
+			if f.Since <= 1 {
+				res += f.Type.MinSize(structs)
+			}
+		}
+		return res
+	}
+	if t.IsInt() {
+		return prefixSize(t.Wire)
+	}
+	return scalarSizes[t.Kind]
+}
+
+// prefixSize returns the size of a fixed width wire, 1 for varints as the least size
+func prefixSize(wire string) int {
+	if wire == "varint" {
+		return 1
+	}
+	return wireSizes[wire]
+}
+
+// wireRange returns signedness and bits of the values an integer wire can hold
+func (t *binType) wireRange() (bool, int) {
+	if t.Wire == "varint" {
+		return t.Signed(), 64
+	}
+	return t.Wire[0] == 'i', 8 * wireSizes[t.Wire]
+}
+
+// goRange returns signedness and bits of the Go integer type, int and uint are
+// intBits wide, as their width depends on the platform
+func (t *binType) goRange(intBits int) (bool, int) {
+	if t.Kind == "int" || t.Kind == "uint" {
+		return t.Signed(), intBits
+	}
+	return t.Signed(), 8 * scalarSizes[t.Kind]
+}
+
+// fits reports whether every value of range a belongs to range b
+func fits(aSigned bool, aBits int, bSigned bool, bBits int) bool {
+	switch {
+	case aSigned == bSigned:
+		return aBits <= bBits
+	case !aSigned && bSigned:
+		return aBits < bBits
+	}
+	return false
+}
+
+type generator struct {
+	imports map[string]bool
+	structs map[string]*binStruct
+}
+
+// v returns a variable name unique for the nesting depth
+func v(name string, depth int) string {
+	if depth == 0 {
+		return name
+	}
+	return name + strconv.Itoa(depth)
+}
+
+// unblock removes braces around a block put into a loop body
+func unblock(code string) string {
+	if strings.HasPrefix(code, "{\n") && strings.HasSuffix(code, "\n}") {
+		return code[2 : len(code)-2]
+	}
+	return code
+}
+
+const readErr = `err != nil {
+		return err
+	}`
+
+// lenArgs returns the order and size arguments of binpackReadLen and binpackReadBytes,
+// size 0 means a varint
+func lenArgs(t *binType) string {
+	return t.Order + `, ` + strconv.Itoa(wireSizes[t.Prefix])
+}
+
+// decode returns code reading target, path is the field name used in errors.
+// max limits the length of strings, []byte and slices, 0 means no limit.
+func (g *generator) decode(t *binType, target, path string, max, depth int) string {
+	field := strconv.Quote(path)
+	switch t.Kind {
+	case "string", "bytes":
+		b := v("b", depth)
+		conv := b
+		if t.Kind == "string" {
+			conv = "string(" + b + ")"
+		}
+		return `{
+	` + b + `, err := binpackReadBytes(r, ` + lenArgs(t) + `, ` + field + `, ` + strconv.Itoa(max) + `)
+	if ` + readErr + `
+	` + target + ` = ` + conv + `
+}`
+	case "marshaler":
+		b := v("b", depth)
+		return `{
+	` + b + `, err := binpackReadBytes(r, ` + lenArgs(t) + `, ` + field + `, ` + strconv.Itoa(max) + `)
+	if ` + readErr + `
+	if err := ` + target + `.UnmarshalBinary(` + b + `); err != nil {
+		return fmt.Errorf("` + path + `: %s", err)
+	}
+}`
+	case "array":
+		if t.Fixed() {
+			break
+		}
+		i := v("i", depth)
+		return `for ` + i + ` := range ` + target + ` {
+	` + unblock(g.decode(t.Elem, target+"["+i+"]", path, 0, depth+1)) + `
+}`
+	case "slice":
+		n, i := v("n", depth), v("i", depth)
+		res := `{
+	` + n + `, err := binpackReadLen(r, ` + lenArgs(t) + `, ` + field + `, ` + strconv.Itoa(max) + `, ` + strconv.Itoa(t.Elem.MinSize(g.structs)) + `)
+	if ` + readErr + `
+	` + target + ` = nil
+	if ` + n + ` > 0 {
+		` + target + ` = make(` + t.Name + `, ` + n + `)
+		`
+		if t.Elem.Fixed() {
+			res += `if err := binpackRead(r, ` + t.Order + `, ` + field + `, ` + target + `); ` + readErr
+		} else {
+			res += `for ` + i + ` := range ` + target + ` {
+			` + unblock(g.decode(t.Elem, target+"["+i+"]", path, 0, depth+1)) + `
+		}`
+		}
+		return res + `
+	}
+}`
+	case "struct":
+		return `if err := ` + target + `.binpackUnpack(r); ` + readErr
+	}
+	if t.IsInt() && !t.Fixed() {
+		return g.decodeInt(t, target, path, depth)
+	}
+	return `if err := binpackRead(r, ` + t.Order + `, ` + field + `, &` + target + `); ` + readErr
+}
+
+// decodeInt returns code reading an integer with a wire other than its Go type
+func (g *generator) decodeInt(t *binType, target, path string, depth int) string {
+	x := v("x", depth)
+	field := strconv.Quote(path)
+	wireSigned, _ := t.wireRange()
+
+	read := ""
+	switch {
+	case t.Wire == "varint" && wireSigned:
+		read = `binpackReadVarint(r, ` + field + `)`
+	case t.Wire == "varint":
+		read = `binpackReadUvarint(r, ` + field + `)`
+	case wireSigned:
+		read = `binpackReadInt(r, ` + t.Order + `, ` + strconv.Itoa(wireSizes[t.Wire]) + `, ` + field + `)`
+	default:
+		read = `binpackReadUint(r, ` + t.Order + `, ` + strconv.Itoa(wireSizes[t.Wire]) + `, ` + field + `)`
+	}
+
+	return `{
+	` + x + `, err := ` + read + `
+	if ` + readErr + intOverflow(t, x, path, "return ") + `
+	` + target + ` = ` + t.Name + `(` + x + `)
+}`
+}
+
+// intOverflow returns code reporting x read from the wire which does not fit the Go type,
+// ret starts the return statement of the generated function
+func intOverflow(t *binType, x, path, ret string) string {
+	wireSigned, wireBits := t.wireRange()
+	goSigned, goBits := t.goRange(32)
+	if fits(wireSigned, wireBits, goSigned, goBits) {
+		return ""
+	}
+	xType := "uint64"
+	if wireSigned {
+		xType = "int64"
+	}
+	cond := xType + `(` + t.Name + `(` + x + `)) != ` + x
+	switch {
+	case wireSigned && !goSigned:
+		cond += ` || ` + x + ` < 0`
+	case !wireSigned && goSigned:
+		cond += ` || ` + t.Name + `(` + x + `) < 0`
+	}
+	return `
+	if ` + cond + ` {
+		` + ret + `fmt.Errorf("` + path + `: %d overflows ` + t.Name + `", ` + x + `)
+	}`
+}
+
+// encodeUint returns code appending value as a wire wide unsigned integer
+func encodeUint(order, wire, value string) string {
+	switch wire {
+	case "u8", "i8":
+		return `dst = append(dst, byte(` + value + `))`
+	case "varint":
+		return `dst = binary.AppendUvarint(dst, uint64(` + value + `))`
+	}
+	bits := strconv.Itoa(8 * wireSizes[wire])
+	return `dst = ` + order + `.AppendUint` + bits + `(dst, uint` + bits + `(` + value + `))`
+}
+
+func (g *generator) encode(t *binType, target string, depth int) string {
+	switch t.Kind {
+	case "float32", "float64":
+		g.imports["math"] = true
+		bits := strings.TrimPrefix(t.Kind, "float")
+		return `dst = ` + t.Order + `.AppendUint` + bits + `(dst, math.Float` + bits + `bits(` + target + `))`
+	case "bool":
+		return `if ` + target + ` {
+	dst = append(dst, 1)
+} else {
+	dst = append(dst, 0)
+}`
+	case "string", "bytes":
+		return encodeUint(t.Order, t.Prefix, `len(`+target+`)`) + `
+dst = append(dst, ` + target + `...)`
+	case "marshaler":
+		// errors are reported by binpackCheck
+		b := v("b", depth)
+		return `{
+	` + b + `, _ := ` + target + `.MarshalBinary()
+	` + encodeUint(t.Order, t.Prefix, `len(`+b+`)`) + `
+	dst = append(dst, ` + b + `...)
+}`
+	case "array", "slice":
+		res := ""
+		if t.Kind == "slice" {
+			res = encodeUint(t.Order, t.Prefix, `len(`+target+`)`) + "\n"
+		}
+		if t.Kind == "array" && t.Elem.Kind == "uint8" && t.Elem.Fixed() {
+			return `dst = append(dst, ` + target + `[:]...)`
+		}
+		i := v("i", depth)
+		return res + `for ` + i + ` := range ` + target + ` {
+	` + g.encode(t.Elem, target+"["+i+"]", depth+1) + `
+}`
+	case "struct":
+		return `dst = ` + target + `.AppendPack(dst)`
+	}
+	if t.IsInt() {
+		if t.Wire == "varint" && t.Signed() {
+			return `dst = binary.AppendVarint(dst, int64(` + target + `))`
+		}
+		return encodeUint(t.Order, t.Wire, target)
+	}
+	panic("unknown kind " + t.Kind)
+}
+
+// maxLen returns the largest length the prefix can hold as a Go constant, or an empty string if any length fits
+func maxLen(prefix string) string {
+	switch prefix {
+	case "u8":
+		return "math.MaxUint8"
+	case "u16":
+		return "math.MaxUint16"
+	case "u32":
+		return "math.MaxUint32"
+	}
+	return ""
+}
+
+// lengthCheck returns code reporting lengths which do not fit the prefix or max
+func (g *generator) lengthCheck(t *binType, target, path string, max int) string {
+	if max > 0 {
+		return `if len(` + target + `) > ` + strconv.Itoa(max) + ` {
+	return fmt.Errorf("` + path + `: length %d exceeds max ` + strconv.Itoa(max) + `", len(` + target + `))
+}`
+	}
+	limit := maxLen(t.Prefix)
+	if limit == "" {
+		return ""
+	}
+	g.imports["math"] = true
+	return `if uint64(len(` + target + `)) > ` + limit + ` {
+	return fmt.Errorf("` + path + `: length %d does not fit ` + t.Prefix + `", len(` + target + `))
+}`
+}
+
+// intCheck returns code reporting integers which do not fit the wire, or an empty string
+func (g *generator) intCheck(t *binType, target, path string) string {
+	wireSigned, wireBits := t.wireRange()
+	goSigned, goBits := t.goRange(64)
+	if fits(goSigned, goBits, wireSigned, wireBits) {
+		return ""
+	}
+	g.imports["math"] = true
+	bits := strconv.Itoa(wireBits)
+	cond := ""
+	switch {
+	case !wireSigned:
+		cond = target + ` < 0`
+		if goBits > wireBits {
+			cond += ` || uint64(` + target + `) > math.MaxUint` + bits
+		}
+		if !goSigned {
+			cond = `uint64(` + target + `) > math.MaxUint` + bits
+		}
+	case goSigned:
+		cond = `int64(` + target + `) < math.MinInt` + bits + ` || int64(` + target + `) > math.MaxInt` + bits
+	default:
+		cond = `uint64(` + target + `) > math.MaxInt` + bits
+	}
+	return `if ` + cond + ` {
+	return fmt.Errorf("` + path + `: %d does not fit ` + t.Wire + `", ` + target + `)
+}`
+}
+
+// check returns code reporting values which can not be packed, or an empty string
+func (g *generator) check(t *binType, target, path string, max, depth int) string {
+	switch t.Kind {
+	case "string", "bytes":
+		return g.lengthCheck(t, target, path, max)
+	case "marshaler":
+		b := v("b", depth)
+		res := `{
+	` + b + `, err := ` + target + `.MarshalBinary()
+	if err != nil {
+		return fmt.Errorf("` + path + `: %s", err)
+	}`
+		if check := g.lengthCheck(t, b, path, max); check != "" {
+			res += "\n\t" + strings.ReplaceAll(check, "\n", "\n\t")
+		}
+		return res + "\n}"
+	case "array", "slice":
+		elem := g.check(t.Elem, target+"["+v("i", depth)+"]", path, 0, depth+1)
+		res := ""
+		if t.Kind == "slice" {
+			res = g.lengthCheck(t, target, path, max)
+		}
+		if elem != "" {
+			if res != "" {
+				res += "\n"
+			}
+			res += `for ` + v("i", depth) + ` := range ` + target + ` {
+	` + elem + `
+}`
+		}
+		return res
+	case "struct":
+		return `if err := ` + target + `.binpackCheck(); err != nil {
+	return err
+}`
+	}
+	if t.IsInt() {
+		return g.intCheck(t, target, path)
+	}
+	return ""
+}
+
+// sample returns a Go literal of the type with non-zero values, not longer than max if it is set
+func (g *generator) sample(t *binType, name string, seed, max, depth int) string {
+	text := strings.ToLower(name) + "-" + strconv.Itoa(seed)
+	if max > 0 && len(text) > max {
+		text = text[:max]
+	}
+	switch t.Kind {
+	case "float32", "float64":
+		return strconv.Itoa(seed) + ".25"
+	case "bool":
+		return "true"
+	case "string":
+		return strconv.Quote(text)
+	case "bytes":
+		return t.Name + "(" + strconv.Quote(text) + ")"
+	case "marshaler":
+		return zeroValue(t)
+	case "array", "slice":
+		n := 2
+		if t.Kind == "array" && t.Len < n {
+			n = t.Len
+		}
+		if t.Kind == "slice" && max > 0 && max < n {
+			n = max
+		}
+		if t.Kind == "slice" && depth > 2 {
+			// recursive types end here
+			return "nil"
+		}
+		elems := make([]string, n)
+		for i := range elems {
+			elems[i] = g.sample(t.Elem, name, seed+i+1, 0, depth+1)
+		}
+		return t.Name + "{" + strings.Join(elems, ", ") + "}"
+	case "struct":
+		st := g.structs[t.Name]
+		fields := make([]string, len(st.Fields))
+		for i, f := range st.Fields {
+			fields[i] = f.Name + ": " + g.sample(f.Type, f.Name, seed+i+1, f.Max, depth+1)
+		}
+		return t.Name + "{" + strings.Join(fields, ", ") + "}"
+	}
+	if t.IsInt() {
+		// negative only if both the Go type and the wire can hold it, small enough for any width
+		if wireSigned, _ := t.wireRange(); wireSigned && t.Signed() {
+			return "-" + strconv.Itoa(seed*11%100)
+		}
+		return strconv.Itoa(seed * 13 % 200)
+	}
+	panic("unknown kind " + t.Kind)
+}
+
+// tooLong returns a Go literal of the type one element longer than max
+func tooLong(t *binType, max int) string {
+	n := strconv.Itoa(max + 1)
+	if t.Kind == "string" {
+		return `strings.Repeat("x", ` + n + `)`
+	}
+	return `make(` + t.Name + `, ` + n + `)`
+}
@@ -0,0 +1,79 @@
+package main
+
+import "text/template"
+
+// versioned records start with a header: the version as uint16 and the length of
+// the fields as uint32, both in the byte order of the struct
+var versionHelpersTpl = template.Must(template.New("versionHelpersTpl").Parse(`
+// binpackReadVersion reads the header of a versioned record and returns the version with the offset the record ends at.
+func binpackReadVersion(r *bytes.Reader, order binary.ByteOrder, name string) (uint64, int, error) {
+	off := binpackOffset(r)
+	version, err := binpackReadUint(r, order, 2, name)
+	if err != nil {
+		return 0, 0, err
+	}
+	if version == 0 {
+		return 0, 0, fmt.Errorf("binpack: %s at offset %d: bad version 0", name, off)
+	}
+	size, err := binpackReadLen(r, order, 4, name, 0, 1)
+	if err != nil {
+		return 0, 0, err
+	}
+	return version, binpackOffset(r) + size, nil
+}
+
+// binpackSkipRecord moves r to the end of a record of a newer version than known, skipping the fields
+// this version does not know. Records of known versions must end exactly at end.
+func binpackSkipRecord(r *bytes.Reader, name string, version uint64, known, end int) error {
+	off := binpackOffset(r)
+	if off == end {
+		return nil
+	}
+	if version <= uint64(known) {
+		return &ErrTrailingData{name, off}
+	}
+	_, err := r.Seek(int64(end), io.SeekStart)
+	return err
+}
+{{if .}}
+// binpackVersionAt is binpackReadVersion over data at off, it also returns the offset of the first field.
+func binpackVersionAt(data []byte, off int, order binary.ByteOrder, name string) (uint64, int, int, error) {
+	version, next, err := binpackUintAt(data, off, order, 2, name)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	if version == 0 {
+		return 0, 0, 0, fmt.Errorf("binpack: %s at offset %d: bad version 0", name, off)
+	}
+	size, next, err := binpackLenAt(data, next, order, 4, name, 0, 1)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	return version, next, next + size, nil
+}
+
+// binpackSkipAt is binpackSkipRecord over data, it returns the offset after the record.
+func binpackSkipAt(name string, version uint64, known, off, end int) (int, error) {
+	if off != end && version <= uint64(known) {
+		return 0, &ErrTrailingData{name, off}
+	}
+	return end, nil
+}
+{{end}}`))
+
+// zeroValue returns a Go literal of the zero value of the type
+func zeroValue(t *binType) string {
+	switch t.Kind {
+	case "bool":
+		return "false"
+	case "string":
+		return `""`
+	case "bytes", "slice":
+		return "nil"
+	case "array", "struct":
+		return t.Name + "{}"
+	case "marshaler":
+		return "*new(" + t.Name + ")"
+	}
+	return "0"
+}
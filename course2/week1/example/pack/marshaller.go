@@ -1,26 +1,1363 @@
+// Code generated by binpack codegen; DO NOT EDIT.
+
 package main
 
-import "encoding/binary"
-import "bytes"
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"unsafe"
+)
+
+// ErrTruncated is returned by Unpack when data ends before Field is read completely.
+type ErrTruncated struct {
+	Field  string
+	Offset int
+}
+
+func (e *ErrTruncated) Error() string {
+	return fmt.Sprintf("binpack: %s truncated at offset %d", e.Field, e.Offset)
+}
+
+// ErrTooLong is returned by Unpack when a length of Field exceeds its cgen max tag.
+type ErrTooLong struct {
+	Field  string
+	Offset int
+	Len    uint64
+	Max    int
+}
+
+func (e *ErrTooLong) Error() string {
+	return fmt.Sprintf("binpack: %s length %d at offset %d exceeds max %d", e.Field, e.Len, e.Offset, e.Max)
+}
+
+// ErrTrailingData is returned by Unpack of strict structs when data has bytes after the last field,
+// and of versioned ones when a record of a known version has bytes after its last field.
+type ErrTrailingData struct {
+	Type   string
+	Offset int
+}
+
+func (e *ErrTrailingData) Error() string {
+	return fmt.Sprintf("binpack: %s ends at offset %d, data has trailing bytes", e.Type, e.Offset)
+}
+
+func binpackOffset(r *bytes.Reader) int {
+	return int(r.Size()) - r.Len()
+}
+
+func binpackRead(r *bytes.Reader, order binary.ByteOrder, field string, v interface{}) error {
+	off := binpackOffset(r)
+	if err := binary.Read(r, order, v); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return &ErrTruncated{field, off}
+		}
+		return err
+	}
+	return nil
+}
+
+// binpackReadUint reads an unsigned integer of size bytes, size 0 means a varint.
+func binpackReadUint(r *bytes.Reader, order binary.ByteOrder, size int, field string) (uint64, error) {
+	switch size {
+	case 0:
+		return binpackReadUvarint(r, field)
+	case 1:
+		var v uint8
+		err := binpackRead(r, order, field, &v)
+		return uint64(v), err
+	case 2:
+		var v uint16
+		err := binpackRead(r, order, field, &v)
+		return uint64(v), err
+	case 4:
+		var v uint32
+		err := binpackRead(r, order, field, &v)
+		return uint64(v), err
+	}
+	var v uint64
+	err := binpackRead(r, order, field, &v)
+	return v, err
+}
+
+// binpackReadInt reads a signed integer of size bytes.
+func binpackReadInt(r *bytes.Reader, order binary.ByteOrder, size int, field string) (int64, error) {
+	v, err := binpackReadUint(r, order, size, field)
+	switch size {
+	case 1:
+		return int64(int8(v)), err
+	case 2:
+		return int64(int16(v)), err
+	case 4:
+		return int64(int32(v)), err
+	}
+	return int64(v), err
+}
+
+func binpackReadUvarint(r *bytes.Reader, field string) (uint64, error) {
+	off := binpackOffset(r)
+	v, err := binary.ReadUvarint(r)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return 0, &ErrTruncated{field, off}
+	}
+	if err != nil {
+		return 0, fmt.Errorf("binpack: %s at offset %d: %s", field, off, err)
+	}
+	return v, nil
+}
+
+func binpackReadVarint(r *bytes.Reader, field string) (int64, error) {
+	off := binpackOffset(r)
+	v, err := binary.ReadVarint(r)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return 0, &ErrTruncated{field, off}
+	}
+	if err != nil {
+		return 0, fmt.Errorf("binpack: %s at offset %d: %s", field, off, err)
+	}
+	return v, nil
+}
+
+// binpackReadLen reads a length prefix of size bytes and checks it against max and,
+// as every element takes at least elemSize bytes, against the rest of data. So a broken
+// or malicious length never makes the caller allocate more than data could hold.
+func binpackReadLen(r *bytes.Reader, order binary.ByteOrder, size int, field string, max, elemSize int) (int, error) {
+	off := binpackOffset(r)
+	n, err := binpackReadUint(r, order, size, field)
+	if err != nil {
+		return 0, err
+	}
+	if max > 0 && n > uint64(max) {
+		return 0, &ErrTooLong{field, off, n, max}
+	}
+	if elemSize > 0 && n > uint64(r.Len()/elemSize) {
+		return 0, &ErrTruncated{field, int(r.Size())}
+	}
+	return int(n), nil
+}
+
+func binpackReadBytes(r *bytes.Reader, order binary.ByteOrder, size int, field string, max int) ([]byte, error) {
+	n, err := binpackReadLen(r, order, size, field, max, 1)
+	if err != nil || n == 0 {
+		return nil, err
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, &ErrTruncated{field, binpackOffset(r)}
+	}
+	return buf, nil
+}
+
+// binpackRecord is implemented by every struct marked cgen: binpack.
+type binpackRecord interface {
+	Unpack(data []byte) error
+	AppendPack(dst []byte) []byte
+	binpackCheck() error
+}
+
+// binpackReadFrame reads a frame from r into buf and returns its data with the number of bytes read.
+// The data is copied as it arrives, so a broken length does not allocate more than r holds.
+func binpackReadFrame(r io.Reader, buf *bytes.Buffer, max, off int) ([]byte, int64, error) {
+	var hdr [4]byte
+	n, err := io.ReadFull(r, hdr[:])
+	if err != nil {
+		return nil, int64(n), err
+	}
+	size := binary.LittleEndian.Uint32(hdr[:])
+	if max > 0 && uint64(size) > uint64(max) {
+		return nil, int64(n), &ErrTooLong{"frame", off, uint64(size), max}
+	}
+	buf.Reset()
+	m, err := io.CopyN(buf, r, int64(size))
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return buf.Bytes(), int64(n) + m, err
+}
+
+// binpackWriteFrame packs rec as a frame into buf and writes it to w.
+func binpackWriteFrame(w io.Writer, buf []byte, rec binpackRecord) ([]byte, int64, error) {
+	if err := rec.binpackCheck(); err != nil {
+		return buf, 0, err
+	}
+	buf = rec.AppendPack(append(buf[:0], 0, 0, 0, 0))
+	if uint64(len(buf)-4) > 1<<32-1 {
+		return buf, 0, fmt.Errorf("binpack: frame of %d bytes does not fit uint32 length", len(buf)-4)
+	}
+	binary.LittleEndian.PutUint32(buf, uint32(len(buf)-4))
+	n, err := w.Write(buf)
+	return buf, int64(n), err
+}
+
+// FrameReader reads records written by FrameWriter or WriteTo from a stream.
+// It reads no further than the current frame, so r can be passed on to ReadFrom.
+type FrameReader struct {
+	r   io.Reader
+	max int
+	off int
+	buf bytes.Buffer
+}
+
+// NewFrameReader returns a FrameReader of r which rejects frames longer than max bytes
+// with *ErrTooLong, 0 means no limit.
+func NewFrameReader(r io.Reader, max int) *FrameReader {
+	return &FrameReader{r: r, max: max}
+}
+
+// Next reads the next frame and unpacks it into rec. It returns io.EOF when the stream
+// ends between frames and io.ErrUnexpectedEOF when it ends inside one.
+func (fr *FrameReader) Next(rec binpackRecord) error {
+	data, n, err := binpackReadFrame(fr.r, &fr.buf, fr.max, fr.off)
+	fr.off += int(n)
+	if err != nil {
+		return err
+	}
+	return rec.Unpack(data)
+}
+
+// FrameWriter writes records to a stream as frames, each is the packed length as
+// little endian uint32 followed by the packed record.
+type FrameWriter struct {
+	w   io.Writer
+	buf []byte
+}
+
+// NewFrameWriter returns a FrameWriter of w.
+func NewFrameWriter(w io.Writer) *FrameWriter {
+	return &FrameWriter{w: w}
+}
+
+// Write packs rec and writes it as a frame with a single Write call.
+func (fw *FrameWriter) Write(rec binpackRecord) error {
+	var err error
+	fw.buf, _, err = binpackWriteFrame(fw.w, fw.buf, rec)
+	return err
+}
+
+// binpackUvarintSize returns the number of bytes binary.AppendUvarint appends for x.
+func binpackUvarintSize(x uint64) int {
+	n := 1
+	for ; x >= 0x80; x >>= 7 {
+		n++
+	}
+	return n
+}
+
+// binpackVarintSize returns the number of bytes binary.AppendVarint appends for x.
+func binpackVarintSize(x int64) int {
+	return binpackUvarintSize(uint64(x)<<1 ^ uint64(x>>63))
+}
+
+// binpackVarintErr returns the error of binary.Uvarint or binary.Varint result n as Unpack reports it,
+// binary.ReadUvarint reports an overflow after 10 bytes even at the end of data.
+func binpackVarintErr(data []byte, off, n int, field string) error {
+	if n == 0 && len(data)-off < binary.MaxVarintLen64 {
+		return &ErrTruncated{field, off}
+	}
+	return fmt.Errorf("binpack: %s at offset %d: binary: varint overflows a 64-bit integer", field, off)
+}
+
+// binpackUvarintAt reads a varint at off and returns it with the offset after it.
+func binpackUvarintAt(data []byte, off int, field string) (uint64, int, error) {
+	v, n := binary.Uvarint(data[off:])
+	if n <= 0 {
+		return 0, 0, binpackVarintErr(data, off, n, field)
+	}
+	return v, off + n, nil
+}
+
+// binpackVarintAt reads a zigzag encoded varint at off and returns it with the offset after it.
+func binpackVarintAt(data []byte, off int, field string) (int64, int, error) {
+	v, n := binary.Varint(data[off:])
+	if n <= 0 {
+		return 0, 0, binpackVarintErr(data, off, n, field)
+	}
+	return v, off + n, nil
+}
+
+// binpackUintAt reads an unsigned integer of size bytes at off, size 0 means a varint.
+// It returns the integer with the offset after it.
+func binpackUintAt(data []byte, off int, order binary.ByteOrder, size int, field string) (uint64, int, error) {
+	if size == 0 {
+		return binpackUvarintAt(data, off, field)
+	}
+	if len(data)-off < size {
+		return 0, 0, &ErrTruncated{field, off}
+	}
+	switch size {
+	case 1:
+		return uint64(data[off]), off + 1, nil
+	case 2:
+		return uint64(order.Uint16(data[off:])), off + 2, nil
+	case 4:
+		return uint64(order.Uint32(data[off:])), off + 4, nil
+	}
+	return order.Uint64(data[off:]), off + 8, nil
+}
+
+// binpackIntAt reads a signed integer of size bytes at off and returns it with the offset after it.
+func binpackIntAt(data []byte, off int, order binary.ByteOrder, size int, field string) (int64, int, error) {
+	v, next, err := binpackUintAt(data, off, order, size, field)
+	switch size {
+	case 1:
+		return int64(int8(v)), next, err
+	case 2:
+		return int64(int16(v)), next, err
+	case 4:
+		return int64(int32(v)), next, err
+	}
+	return int64(v), next, err
+}
+
+// binpackLenAt is binpackReadLen over data at off, it returns the length with the offset after it.
+func binpackLenAt(data []byte, off int, order binary.ByteOrder, size int, field string, max, elemSize int) (int, int, error) {
+	n, next, err := binpackUintAt(data, off, order, size, field)
+	if err != nil {
+		return 0, 0, err
+	}
+	if max > 0 && n > uint64(max) {
+		return 0, 0, &ErrTooLong{field, off, n, max}
+	}
+	if elemSize > 0 && n > uint64((len(data)-next)/elemSize) {
+		return 0, 0, &ErrTruncated{field, len(data)}
+	}
+	return int(n), next, nil
+}
+
+var binpackCastagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrChecksum is returned by Unpack when the checksum of Type at Offset does not match the data.
+type ErrChecksum struct {
+	Type     string
+	Offset   int
+	Expected uint32 // read from data
+	Actual   uint32 // of the data
+}
+
+func (e *ErrChecksum) Error() string {
+	return fmt.Sprintf("binpack: %s checksum at offset %d is %08x, data has %08x", e.Type, e.Offset, e.Expected, e.Actual)
+}
+
+// binpackCheckCRC reads the checksum at the offset of r and compares it with the one of data from start up to it.
+func binpackCheckCRC(r *bytes.Reader, order binary.ByteOrder, table *crc32.Table, name string, start int) error {
+	end := binpackOffset(r)
+	var buf [512]byte
+	crc := uint32(0)
+	for off := start; off < end; {
+		n := end - off
+		if n > len(buf) {
+			n = len(buf)
+		}
+		r.ReadAt(buf[:n], int64(off))
+		crc = crc32.Update(crc, table, buf[:n])
+		off += n
+	}
+	var sum uint32
+	if err := binpackRead(r, order, name+".checksum", &sum); err != nil {
+		return err
+	}
+	if sum != crc {
+		return &ErrChecksum{name, end, sum, crc}
+	}
+	return nil
+}
+
+// binpackCRCAt is binpackCheckCRC over data at off, it returns the offset after the checksum.
+func binpackCRCAt(data []byte, off int, order binary.ByteOrder, table *crc32.Table, name string, start int) (int, error) {
+	if len(data)-off < 4 {
+		return 0, &ErrTruncated{name + ".checksum", off}
+	}
+	if sum, crc := order.Uint32(data[off:]), crc32.Checksum(data[start:off], table); sum != crc {
+		return 0, &ErrChecksum{name, off, sum, crc}
+	}
+	return off + 4, nil
+}
+
+// binpackReadVersion reads the header of a versioned record and returns the version with the offset the record ends at.
+func binpackReadVersion(r *bytes.Reader, order binary.ByteOrder, name string) (uint64, int, error) {
+	off := binpackOffset(r)
+	version, err := binpackReadUint(r, order, 2, name)
+	if err != nil {
+		return 0, 0, err
+	}
+	if version == 0 {
+		return 0, 0, fmt.Errorf("binpack: %s at offset %d: bad version 0", name, off)
+	}
+	size, err := binpackReadLen(r, order, 4, name, 0, 1)
+	if err != nil {
+		return 0, 0, err
+	}
+	return version, binpackOffset(r) + size, nil
+}
+
+// binpackSkipRecord moves r to the end of a record of a newer version than known, skipping the fields
+// this version does not know. Records of known versions must end exactly at end.
+func binpackSkipRecord(r *bytes.Reader, name string, version uint64, known, end int) error {
+	off := binpackOffset(r)
+	if off == end {
+		return nil
+	}
+	if version <= uint64(known) {
+		return &ErrTrailingData{name, off}
+	}
+	_, err := r.Seek(int64(end), io.SeekStart)
+	return err
+}
+
+// binpackVersionAt is binpackReadVersion over data at off, it also returns the offset of the first field.
+func binpackVersionAt(data []byte, off int, order binary.ByteOrder, name string) (uint64, int, int, error) {
+	version, next, err := binpackUintAt(data, off, order, 2, name)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	if version == 0 {
+		return 0, 0, 0, fmt.Errorf("binpack: %s at offset %d: bad version 0", name, off)
+	}
+	size, next, err := binpackLenAt(data, next, order, 4, name, 0, 1)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	return version, next, next + size, nil
+}
+
+// binpackSkipAt is binpackSkipRecord over data, it returns the offset after the record.
+func binpackSkipAt(name string, version uint64, known, off, end int) (int, error) {
+	if off != end && version <= uint64(known) {
+		return 0, &ErrTrailingData{name, off}
+	}
+	return end, nil
+}
 
+// Unpack reads in from data in the layout written by Pack.
+// Errors are *ErrTruncated, *ErrTooLong, *ErrTrailingData or report values which do not fit Go types.
 func (in *User) Unpack(data []byte) error {
 	r := bytes.NewReader(data)
+	if err := in.binpackUnpack(r); err != nil {
+		return err
+	}
+	if r.Len() > 0 {
+		return &ErrTrailingData{"User", binpackOffset(r)}
+	}
+	return nil
+}
 
+func (in *User) binpackUnpack(r *bytes.Reader) error {
 	// ID
-	var IDRaw uint32
-	binary.Read(r, binary.LittleEndian, &IDRaw)
-	in.ID = int(IDRaw)
+	{
+		x, err := binpackReadUint(r, binary.LittleEndian, 4, "User.ID")
+		if err != nil {
+			return err
+		}
+		if uint64(int(x)) != x || int(x) < 0 {
+			return fmt.Errorf("User.ID: %d overflows int", x)
+		}
+		in.ID = int(x)
+	}
 
 	// Login
-	var LoginLenRaw uint32
-	binary.Read(r, binary.LittleEndian, &LoginLenRaw)
-	LoginRaw := make([]byte, LoginLenRaw)
-	binary.Read(r, binary.LittleEndian, &LoginRaw)
-	in.Login = string(LoginRaw)
+	{
+		b, err := binpackReadBytes(r, binary.LittleEndian, 4, "User.Login", 64)
+		if err != nil {
+			return err
+		}
+		in.Login = string(b)
+	}
 
 	// Flags
-	var FlagsRaw uint32
-	binary.Read(r, binary.LittleEndian, &FlagsRaw)
-	in.Flags = int(FlagsRaw)
+	{
+		x, err := binpackReadUint(r, binary.LittleEndian, 4, "User.Flags")
+		if err != nil {
+			return err
+		}
+		if uint64(int(x)) != x || int(x) < 0 {
+			return fmt.Errorf("User.Flags: %d overflows int", x)
+		}
+		in.Flags = int(x)
+	}
+
+	return nil
+}
+
+// ReadFrom reads a single frame written by WriteTo or FrameWriter from r and unpacks it.
+// It returns io.EOF if r ends before the frame and io.ErrUnexpectedEOF if r ends inside it.
+func (in *User) ReadFrom(r io.Reader) (int64, error) {
+	data, n, err := binpackReadFrame(r, &bytes.Buffer{}, 0, 0)
+	if err != nil {
+		return n, err
+	}
+	return n, in.Unpack(data)
+}
+
+// WriteTo writes in to w as a frame, see FrameWriter.
+func (in *User) WriteTo(w io.Writer) (int64, error) {
+	_, n, err := binpackWriteFrame(w, nil, in)
+	return n, err
+}
+
+// UnpackFast is Unpack reading data in place by offsets, without a reader and reflection.
+// It returns the same errors as Unpack. Fields tagged alias share memory with data,
+// data must not be modified while they are used.
+func (in *User) UnpackFast(data []byte) error {
+	off, err := in.binpackUnpackFast(data, 0)
+	if err != nil {
+		return err
+	}
+	if off < len(data) {
+		return &ErrTrailingData{"User", off}
+	}
+	return nil
+}
+
+func (in *User) binpackUnpackFast(data []byte, off int) (int, error) {
+	// ID
+	{
+		x, next, err := binpackUintAt(data, off, binary.LittleEndian, 4, "User.ID")
+		if err != nil {
+			return 0, err
+		}
+		if uint64(int(x)) != x || int(x) < 0 {
+			return 0, fmt.Errorf("User.ID: %d overflows int", x)
+		}
+		in.ID = int(x)
+		off = next
+	}
+
+	// Login
+	{
+		n, next, err := binpackLenAt(data, off, binary.LittleEndian, 4, "User.Login", 64, 1)
+		if err != nil {
+			return 0, err
+		}
+		in.Login = ""
+		if n > 0 {
+			in.Login = unsafe.String(&data[next], n)
+		}
+		off = next + n
+	}
+
+	// Flags
+	{
+		x, next, err := binpackUintAt(data, off, binary.LittleEndian, 4, "User.Flags")
+		if err != nil {
+			return 0, err
+		}
+		if uint64(int(x)) != x || int(x) < 0 {
+			return 0, fmt.Errorf("User.Flags: %d overflows int", x)
+		}
+		in.Flags = int(x)
+		off = next
+	}
+
+	return off, nil
+}
+
+// UserPackTemplate is the perl pack template of the User layout.
+const UserPackTemplate = "V V/a* V"
+
+// Pack returns the binary representation of in, it is read back by Unpack.
+func (in *User) Pack() ([]byte, error) {
+	if err := in.binpackCheck(); err != nil {
+		return nil, err
+	}
+	return in.AppendPack(make([]byte, 0, in.PackedSize())), nil
+}
+
+// binpackCheck reports values AppendPack can not represent or Unpack would reject, like too long strings.
+func (in *User) binpackCheck() error {
+	// ID
+	if in.ID < 0 || uint64(in.ID) > math.MaxUint32 {
+		return fmt.Errorf("User.ID: %d does not fit u32", in.ID)
+	}
+
+	// Login
+	if len(in.Login) > 64 {
+		return fmt.Errorf("User.Login: length %d exceeds max 64", len(in.Login))
+	}
+
+	// Flags
+	if in.Flags < 0 || uint64(in.Flags) > math.MaxUint32 {
+		return fmt.Errorf("User.Flags: %d does not fit u32", in.Flags)
+	}
+
+	return nil
+}
+
+// AppendPack appends the binary representation of in to dst.
+// Values must fit the wire types, Pack checks it.
+func (in *User) AppendPack(dst []byte) []byte {
+	// ID
+	dst = binary.LittleEndian.AppendUint32(dst, uint32(in.ID))
+
+	// Login
+	dst = binary.LittleEndian.AppendUint32(dst, uint32(len(in.Login)))
+	dst = append(dst, in.Login...)
+
+	// Flags
+	dst = binary.LittleEndian.AppendUint32(dst, uint32(in.Flags))
+
+	return dst
+}
+
+// PackedSize returns the length of the binary representation of in, Pack allocates exactly it.
+func (in *User) PackedSize() int {
+	size := 8
+	// Login
+	size += 4 + len(in.Login)
+	return size
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler with Pack.
+func (in *User) MarshalBinary() ([]byte, error) {
+	return in.Pack()
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler with Unpack,
+// which does not keep data, callers may reuse it.
+func (in *User) UnmarshalBinary(data []byte) error {
+	return in.Unpack(data)
+}
+
+// Unpack reads in from data in the layout written by Pack.
+// Errors are *ErrTruncated, *ErrTooLong, *ErrTrailingData, *ErrChecksum or report values which do not fit Go types.
+func (in *Session) Unpack(data []byte) error {
+	r := bytes.NewReader(data)
+	if err := in.binpackUnpack(r); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (in *Session) binpackUnpack(r *bytes.Reader) error {
+	version, end, err := binpackReadVersion(r, binary.LittleEndian, "Session")
+	if err != nil {
+		return err
+	}
+
+	start := binpackOffset(r)
+
+	// User
+	if err := in.User.binpackUnpack(r); err != nil {
+		return err
+	}
+	if binpackOffset(r) > end {
+		return &ErrTruncated{"Session.User", end}
+	}
+
+	// Token
+	if err := binpackRead(r, binary.LittleEndian, "Session.Token", &in.Token); err != nil {
+		return err
+	}
+	if binpackOffset(r) > end {
+		return &ErrTruncated{"Session.Token", end}
+	}
+
+	// Expires
+	if err := binpackRead(r, binary.LittleEndian, "Session.Expires", &in.Expires); err != nil {
+		return err
+	}
+	if binpackOffset(r) > end {
+		return &ErrTruncated{"Session.Expires", end}
+	}
+
+	// Admin
+	if err := binpackRead(r, binary.LittleEndian, "Session.Admin", &in.Admin); err != nil {
+		return err
+	}
+	if binpackOffset(r) > end {
+		return &ErrTruncated{"Session.Admin", end}
+	}
+
+	// Scores
+	{
+		n, err := binpackReadLen(r, binary.LittleEndian, 4, "Session.Scores", 0, 4)
+		if err != nil {
+			return err
+		}
+		in.Scores = nil
+		if n > 0 {
+			in.Scores = make([]float32, n)
+			if err := binpackRead(r, binary.LittleEndian, "Session.Scores", in.Scores); err != nil {
+				return err
+			}
+		}
+	}
+	if binpackOffset(r) > end {
+		return &ErrTruncated{"Session.Scores", end}
+	}
+
+	// Roles
+	{
+		n, err := binpackReadLen(r, binary.LittleEndian, 4, "Session.Roles", 16, 4)
+		if err != nil {
+			return err
+		}
+		in.Roles = nil
+		if n > 0 {
+			in.Roles = make([]string, n)
+			for i := range in.Roles {
+				b1, err := binpackReadBytes(r, binary.LittleEndian, 4, "Session.Roles", 0)
+				if err != nil {
+					return err
+				}
+				in.Roles[i] = string(b1)
+			}
+		}
+	}
+	if binpackOffset(r) > end {
+		return &ErrTruncated{"Session.Roles", end}
+	}
+
+	// Payload
+	{
+		b, err := binpackReadBytes(r, binary.LittleEndian, 4, "Session.Payload", 0)
+		if err != nil {
+			return err
+		}
+		in.Payload = b
+	}
+	if binpackOffset(r) > end {
+		return &ErrTruncated{"Session.Payload", end}
+	}
+
+	// Device
+	if version >= 2 {
+		{
+			b, err := binpackReadBytes(r, binary.LittleEndian, 4, "Session.Device", 32)
+			if err != nil {
+				return err
+			}
+			in.Device = string(b)
+		}
+	} else {
+		in.Device = ""
+	}
+
+	// checksum
+	if err := binpackCheckCRC(r, binary.LittleEndian, crc32.IEEETable, "Session", start); err != nil {
+		return err
+	}
+	if binpackOffset(r) > end {
+		return &ErrTruncated{"Session.Device", end}
+	}
+
+	return binpackSkipRecord(r, "Session", version, 2, end)
+}
+
+// ReadFrom reads a single frame written by WriteTo or FrameWriter from r and unpacks it.
+// It returns io.EOF if r ends before the frame and io.ErrUnexpectedEOF if r ends inside it.
+func (in *Session) ReadFrom(r io.Reader) (int64, error) {
+	data, n, err := binpackReadFrame(r, &bytes.Buffer{}, 0, 0)
+	if err != nil {
+		return n, err
+	}
+	return n, in.Unpack(data)
+}
+
+// WriteTo writes in to w as a frame, see FrameWriter.
+func (in *Session) WriteTo(w io.Writer) (int64, error) {
+	_, n, err := binpackWriteFrame(w, nil, in)
+	return n, err
+}
+
+// UnpackFast is Unpack reading data in place by offsets, without a reader and reflection.
+// It returns the same errors as Unpack.
+func (in *Session) UnpackFast(data []byte) error {
+	_, err := in.binpackUnpackFast(data, 0)
+	return err
+}
+
+func (in *Session) binpackUnpackFast(data []byte, off int) (int, error) {
+	version, off, end, err := binpackVersionAt(data, off, binary.LittleEndian, "Session")
+	if err != nil {
+		return 0, err
+	}
+
+	start := off
+
+	// User
+	{
+		next, err := in.User.binpackUnpackFast(data, off)
+		if err != nil {
+			return 0, err
+		}
+		off = next
+	}
+	if off > end {
+		return 0, &ErrTruncated{"Session.User", end}
+	}
+
+	// Token
+	if len(data)-off < 16 {
+		return 0, &ErrTruncated{"Session.Token", off}
+	}
+	copy(in.Token[:], data[off:])
+	off += 16
+	if off > end {
+		return 0, &ErrTruncated{"Session.Token", end}
+	}
+
+	// Expires
+	if len(data)-off < 8 {
+		return 0, &ErrTruncated{"Session.Expires", off}
+	}
+	in.Expires = int64(binary.LittleEndian.Uint64(data[off:]))
+	off += 8
+	if off > end {
+		return 0, &ErrTruncated{"Session.Expires", end}
+	}
+
+	// Admin
+	if len(data)-off < 1 {
+		return 0, &ErrTruncated{"Session.Admin", off}
+	}
+	in.Admin = data[off] != 0
+	off += 1
+	if off > end {
+		return 0, &ErrTruncated{"Session.Admin", end}
+	}
+
+	// Scores
+	{
+		n, next, err := binpackLenAt(data, off, binary.LittleEndian, 4, "Session.Scores", 0, 4)
+		if err != nil {
+			return 0, err
+		}
+		off = next
+		in.Scores = nil
+		if n > 0 {
+			in.Scores = make([]float32, n)
+			for i := range in.Scores {
+				in.Scores[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[off:]))
+				off += 4
+			}
+		}
+	}
+	if off > end {
+		return 0, &ErrTruncated{"Session.Scores", end}
+	}
+
+	// Roles
+	{
+		n, next, err := binpackLenAt(data, off, binary.LittleEndian, 4, "Session.Roles", 16, 4)
+		if err != nil {
+			return 0, err
+		}
+		off = next
+		in.Roles = nil
+		if n > 0 {
+			in.Roles = make([]string, n)
+			for i := range in.Roles {
+				n1, next1, err := binpackLenAt(data, off, binary.LittleEndian, 4, "Session.Roles", 0, 1)
+				if err != nil {
+					return 0, err
+				}
+				in.Roles[i] = string(data[next1 : next1+n1])
+				off = next1 + n1
+			}
+		}
+	}
+	if off > end {
+		return 0, &ErrTruncated{"Session.Roles", end}
+	}
+
+	// Payload
+	{
+		n, next, err := binpackLenAt(data, off, binary.LittleEndian, 4, "Session.Payload", 0, 1)
+		if err != nil {
+			return 0, err
+		}
+		in.Payload = nil
+		if n > 0 {
+			in.Payload = make([]byte, n)
+			copy(in.Payload, data[next:])
+		}
+		off = next + n
+	}
+	if off > end {
+		return 0, &ErrTruncated{"Session.Payload", end}
+	}
+
+	// Device
+	if version >= 2 {
+		{
+			n, next, err := binpackLenAt(data, off, binary.LittleEndian, 4, "Session.Device", 32, 1)
+			if err != nil {
+				return 0, err
+			}
+			in.Device = string(data[next : next+n])
+			off = next + n
+		}
+	} else {
+		in.Device = ""
+	}
+
+	// checksum
+	{
+		next, err := binpackCRCAt(data, off, binary.LittleEndian, crc32.IEEETable, "Session", start)
+		if err != nil {
+			return 0, err
+		}
+		off = next
+	}
+	if off > end {
+		return 0, &ErrTruncated{"Session.Device", end}
+	}
+
+	return binpackSkipAt("Session", version, 2, off, end)
+}
+
+// Pack returns the binary representation of in, it is read back by Unpack.
+func (in *Session) Pack() ([]byte, error) {
+	if err := in.binpackCheck(); err != nil {
+		return nil, err
+	}
+	return in.AppendPack(make([]byte, 0, in.PackedSize())), nil
+}
+
+// binpackCheck reports values AppendPack can not represent or Unpack would reject, like too long strings.
+func (in *Session) binpackCheck() error {
+	// User
+	if err := in.User.binpackCheck(); err != nil {
+		return err
+	}
+
+	// Scores
+	if uint64(len(in.Scores)) > math.MaxUint32 {
+		return fmt.Errorf("Session.Scores: length %d does not fit u32", len(in.Scores))
+	}
+
+	// Roles
+	if len(in.Roles) > 16 {
+		return fmt.Errorf("Session.Roles: length %d exceeds max 16", len(in.Roles))
+	}
+	for i := range in.Roles {
+		if uint64(len(in.Roles[i])) > math.MaxUint32 {
+			return fmt.Errorf("Session.Roles: length %d does not fit u32", len(in.Roles[i]))
+		}
+	}
+
+	// Payload
+	if uint64(len(in.Payload)) > math.MaxUint32 {
+		return fmt.Errorf("Session.Payload: length %d does not fit u32", len(in.Payload))
+	}
+
+	// Device
+	if len(in.Device) > 32 {
+		return fmt.Errorf("Session.Device: length %d exceeds max 32", len(in.Device))
+	}
+
+	return nil
+}
+
+// AppendPack appends the binary representation of in to dst.
+// Values must fit the wire types, Pack checks it.
+func (in *Session) AppendPack(dst []byte) []byte {
+	return in.binpackAppendVersion(dst, 2)
+}
+
+// binpackAppendVersion appends a record of the version, without fields newer than it,
+// for readers which do not know the current version yet.
+func (in *Session) binpackAppendVersion(dst []byte, version int) []byte {
+	// the record length is set when the fields are appended
+	dst = binary.LittleEndian.AppendUint16(dst, uint16(version))
+	dst = append(dst, 0, 0, 0, 0)
+	start := len(dst)
+
+	// User
+	dst = in.User.AppendPack(dst)
+
+	// Token
+	dst = append(dst, in.Token[:]...)
+
+	// Expires
+	dst = binary.LittleEndian.AppendUint64(dst, uint64(in.Expires))
+
+	// Admin
+	if in.Admin {
+		dst = append(dst, 1)
+	} else {
+		dst = append(dst, 0)
+	}
+
+	// Scores
+	dst = binary.LittleEndian.AppendUint32(dst, uint32(len(in.Scores)))
+	for i := range in.Scores {
+		dst = binary.LittleEndian.AppendUint32(dst, math.Float32bits(in.Scores[i]))
+	}
+
+	// Roles
+	dst = binary.LittleEndian.AppendUint32(dst, uint32(len(in.Roles)))
+	for i := range in.Roles {
+		dst = binary.LittleEndian.AppendUint32(dst, uint32(len(in.Roles[i])))
+		dst = append(dst, in.Roles[i]...)
+	}
+
+	// Payload
+	dst = binary.LittleEndian.AppendUint32(dst, uint32(len(in.Payload)))
+	dst = append(dst, in.Payload...)
+
+	// Device
+	if version >= 2 {
+		dst = binary.LittleEndian.AppendUint32(dst, uint32(len(in.Device)))
+		dst = append(dst, in.Device...)
+	}
+
+	// checksum
+	dst = binary.LittleEndian.AppendUint32(dst, crc32.Checksum(dst[start:], crc32.IEEETable))
+
+	binary.LittleEndian.PutUint32(dst[start-4:], uint32(len(dst)-start))
+	return dst
+}
+
+// PackedSize returns the length of the binary representation of in, Pack allocates exactly it.
+func (in *Session) PackedSize() int {
+	size := 35
+	// User
+	size += in.User.PackedSize()
+	// Scores
+	size += 4 + 4*len(in.Scores)
+	// Roles
+	size += 4
+	for i := range in.Roles {
+		size += 4 + len(in.Roles[i])
+	}
+	// Payload
+	size += 4 + len(in.Payload)
+	// Device
+	size += 4 + len(in.Device)
+	return size
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler with Pack.
+func (in *Session) MarshalBinary() ([]byte, error) {
+	return in.Pack()
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler with Unpack,
+// which does not keep data, callers may reuse it.
+func (in *Session) UnmarshalBinary(data []byte) error {
+	return in.Unpack(data)
+}
+
+// Unpack reads in from data in the layout written by Pack.
+// Errors are *ErrTruncated, *ErrTooLong, *ErrChecksum or report values which do not fit Go types.
+func (in *Header) Unpack(data []byte) error {
+	r := bytes.NewReader(data)
+	if err := in.binpackUnpack(r); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (in *Header) binpackUnpack(r *bytes.Reader) error {
+	start := binpackOffset(r)
+
+	// Seq
+	{
+		x, err := binpackReadUint(r, binary.BigEndian, 4, "Header.Seq")
+		if err != nil {
+			return err
+		}
+		if uint64(int(x)) != x || int(x) < 0 {
+			return fmt.Errorf("Header.Seq: %d overflows int", x)
+		}
+		in.Seq = int(x)
+	}
+
+	// Kind
+	if err := binpackRead(r, binary.BigEndian, "Header.Kind", &in.Kind); err != nil {
+		return err
+	}
+
+	// checksum
+	if err := binpackCheckCRC(r, binary.BigEndian, binpackCastagnoli, "Header", start); err != nil {
+		return err
+	}
+
+	// Host
+	{
+		b, err := binpackReadBytes(r, binary.BigEndian, 1, "Header.Host", 253)
+		if err != nil {
+			return err
+		}
+		in.Host = string(b)
+	}
+
+	// Path
+	{
+		b, err := binpackReadBytes(r, binary.BigEndian, 0, "Header.Path", 0)
+		if err != nil {
+			return err
+		}
+		in.Path = string(b)
+	}
+
+	// Offsets
+	{
+		n, err := binpackReadLen(r, binary.BigEndian, 2, "Header.Offsets", 0, 1)
+		if err != nil {
+			return err
+		}
+		in.Offsets = nil
+		if n > 0 {
+			in.Offsets = make([]int64, n)
+			for i := range in.Offsets {
+				x1, err := binpackReadVarint(r, "Header.Offsets")
+				if err != nil {
+					return err
+				}
+				in.Offsets[i] = int64(x1)
+			}
+		}
+	}
+
+	// Checksum
+	if err := binpackRead(r, binary.LittleEndian, "Header.Checksum", &in.Checksum); err != nil {
+		return err
+	}
+
+	// Sent
+	{
+		b, err := binpackReadBytes(r, binary.BigEndian, 1, "Header.Sent", 0)
+		if err != nil {
+			return err
+		}
+		if err := in.Sent.UnmarshalBinary(b); err != nil {
+			return fmt.Errorf("Header.Sent: %s", err)
+		}
+	}
+
 	return nil
 }
+
+// ReadFrom reads a single frame written by WriteTo or FrameWriter from r and unpacks it.
+// It returns io.EOF if r ends before the frame and io.ErrUnexpectedEOF if r ends inside it.
+func (in *Header) ReadFrom(r io.Reader) (int64, error) {
+	data, n, err := binpackReadFrame(r, &bytes.Buffer{}, 0, 0)
+	if err != nil {
+		return n, err
+	}
+	return n, in.Unpack(data)
+}
+
+// WriteTo writes in to w as a frame, see FrameWriter.
+func (in *Header) WriteTo(w io.Writer) (int64, error) {
+	_, n, err := binpackWriteFrame(w, nil, in)
+	return n, err
+}
+
+// UnpackFast is Unpack reading data in place by offsets, without a reader and reflection.
+// It returns the same errors as Unpack.
+func (in *Header) UnpackFast(data []byte) error {
+	_, err := in.binpackUnpackFast(data, 0)
+	return err
+}
+
+func (in *Header) binpackUnpackFast(data []byte, off int) (int, error) {
+	start := off
+
+	// Seq
+	{
+		x, next, err := binpackUintAt(data, off, binary.BigEndian, 4, "Header.Seq")
+		if err != nil {
+			return 0, err
+		}
+		if uint64(int(x)) != x || int(x) < 0 {
+			return 0, fmt.Errorf("Header.Seq: %d overflows int", x)
+		}
+		in.Seq = int(x)
+		off = next
+	}
+
+	// Kind
+	if len(data)-off < 2 {
+		return 0, &ErrTruncated{"Header.Kind", off}
+	}
+	in.Kind = uint16(binary.BigEndian.Uint16(data[off:]))
+	off += 2
+
+	// checksum
+	{
+		next, err := binpackCRCAt(data, off, binary.BigEndian, binpackCastagnoli, "Header", start)
+		if err != nil {
+			return 0, err
+		}
+		off = next
+	}
+
+	// Host
+	{
+		n, next, err := binpackLenAt(data, off, binary.BigEndian, 1, "Header.Host", 253, 1)
+		if err != nil {
+			return 0, err
+		}
+		in.Host = string(data[next : next+n])
+		off = next + n
+	}
+
+	// Path
+	{
+		n, next, err := binpackLenAt(data, off, binary.BigEndian, 0, "Header.Path", 0, 1)
+		if err != nil {
+			return 0, err
+		}
+		in.Path = string(data[next : next+n])
+		off = next + n
+	}
+
+	// Offsets
+	{
+		n, next, err := binpackLenAt(data, off, binary.BigEndian, 2, "Header.Offsets", 0, 1)
+		if err != nil {
+			return 0, err
+		}
+		off = next
+		in.Offsets = nil
+		if n > 0 {
+			in.Offsets = make([]int64, n)
+			for i := range in.Offsets {
+				x1, next1, err := binpackVarintAt(data, off, "Header.Offsets")
+				if err != nil {
+					return 0, err
+				}
+				in.Offsets[i] = int64(x1)
+				off = next1
+			}
+		}
+	}
+
+	// Checksum
+	if len(data)-off < 4 {
+		return 0, &ErrTruncated{"Header.Checksum", off}
+	}
+	in.Checksum = uint32(binary.LittleEndian.Uint32(data[off:]))
+	off += 4
+
+	// Sent
+	{
+		n, next, err := binpackLenAt(data, off, binary.BigEndian, 1, "Header.Sent", 0, 1)
+		if err != nil {
+			return 0, err
+		}
+		if err := in.Sent.UnmarshalBinary(data[next : next+n : next+n]); err != nil {
+			return 0, fmt.Errorf("Header.Sent: %s", err)
+		}
+		off = next + n
+	}
+
+	return off, nil
+}
+
+// Pack returns the binary representation of in, it is read back by Unpack.
+func (in *Header) Pack() ([]byte, error) {
+	if err := in.binpackCheck(); err != nil {
+		return nil, err
+	}
+	return in.AppendPack(make([]byte, 0, in.PackedSize())), nil
+}
+
+// binpackCheck reports values AppendPack can not represent or Unpack would reject, like too long strings.
+func (in *Header) binpackCheck() error {
+	// Seq
+	if in.Seq < 0 || uint64(in.Seq) > math.MaxUint32 {
+		return fmt.Errorf("Header.Seq: %d does not fit u32", in.Seq)
+	}
+
+	// Host
+	if len(in.Host) > 253 {
+		return fmt.Errorf("Header.Host: length %d exceeds max 253", len(in.Host))
+	}
+
+	// Offsets
+	if uint64(len(in.Offsets)) > math.MaxUint16 {
+		return fmt.Errorf("Header.Offsets: length %d does not fit u16", len(in.Offsets))
+	}
+
+	// Sent
+	{
+		b, err := in.Sent.MarshalBinary()
+		if err != nil {
+			return fmt.Errorf("Header.Sent: %s", err)
+		}
+		if uint64(len(b)) > math.MaxUint8 {
+			return fmt.Errorf("Header.Sent: length %d does not fit u8", len(b))
+		}
+	}
+
+	return nil
+}
+
+// AppendPack appends the binary representation of in to dst.
+// Values must fit the wire types, Pack checks it.
+func (in *Header) AppendPack(dst []byte) []byte {
+	start := len(dst)
+
+	// Seq
+	dst = binary.BigEndian.AppendUint32(dst, uint32(in.Seq))
+
+	// Kind
+	dst = binary.BigEndian.AppendUint16(dst, uint16(in.Kind))
+
+	// checksum
+	dst = binary.BigEndian.AppendUint32(dst, crc32.Checksum(dst[start:], binpackCastagnoli))
+
+	// Host
+	dst = append(dst, byte(len(in.Host)))
+	dst = append(dst, in.Host...)
+
+	// Path
+	dst = binary.AppendUvarint(dst, uint64(len(in.Path)))
+	dst = append(dst, in.Path...)
+
+	// Offsets
+	dst = binary.BigEndian.AppendUint16(dst, uint16(len(in.Offsets)))
+	for i := range in.Offsets {
+		dst = binary.AppendVarint(dst, int64(in.Offsets[i]))
+	}
+
+	// Checksum
+	dst = binary.LittleEndian.AppendUint32(dst, uint32(in.Checksum))
+
+	// Sent
+	{
+		b, _ := in.Sent.MarshalBinary()
+		dst = append(dst, byte(len(b)))
+		dst = append(dst, b...)
+	}
+
+	return dst
+}
+
+// PackedSize returns the length of the binary representation of in, Pack allocates exactly it.
+func (in *Header) PackedSize() int {
+	size := 14
+	// Host
+	size += 1 + len(in.Host)
+	// Path
+	size += binpackUvarintSize(uint64(len(in.Path))) + len(in.Path)
+	// Offsets
+	size += 2
+	for i := range in.Offsets {
+		size += binpackVarintSize(int64(in.Offsets[i]))
+	}
+	// Sent
+	{
+		b, _ := in.Sent.MarshalBinary()
+		size += 1 + len(b)
+	}
+	return size
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler with Pack.
+func (in *Header) MarshalBinary() ([]byte, error) {
+	return in.Pack()
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler with Unpack,
+// which does not keep data, callers may reuse it.
+func (in *Header) UnmarshalBinary(data []byte) error {
+	return in.Unpack(data)
+}
@@ -0,0 +1,820 @@
+// Code generated by binpack codegen; DO NOT EDIT.
+
+package main
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func binpackSampleUser() User {
+	return User{
+		ID:    13,
+		Login: "login-2",
+		Flags: 39,
+	}
+}
+
+func TestUserPackRoundTrip(t *testing.T) {
+	in := binpackSampleUser()
+
+	data, err := in.Pack()
+	if err != nil {
+		t.Fatalf("Pack: %s", err)
+	}
+	if appended := in.AppendPack([]byte{0xff}); !bytes.Equal(appended[1:], data) || appended[0] != 0xff {
+		t.Fatalf("AppendPack = %v, expected 0xff followed by %v", appended, data)
+	}
+	if size := in.PackedSize(); size != len(data) || cap(data) != len(data) {
+		t.Fatalf("PackedSize = %d, packed %d bytes with capacity %d", size, len(data), cap(data))
+	}
+
+	out := User{}
+	if err := out.Unpack(data); err != nil {
+		t.Fatalf("Unpack: %s", err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("round trip mismatch\nin:  %#v\nout: %#v", in, out)
+	}
+
+	fast := User{}
+	if err := fast.UnpackFast(data); err != nil {
+		t.Fatalf("UnpackFast: %s", err)
+	}
+	if !reflect.DeepEqual(in, fast) {
+		t.Fatalf("UnpackFast round trip mismatch\nin:  %#v\nout: %#v", in, fast)
+	}
+
+	if err := out.Unpack(append(data, 0)); !errors.As(err, new(*ErrTrailingData)) {
+		t.Fatalf("Unpack with a trailing byte: got %v, expected *ErrTrailingData", err)
+	}
+	if err := out.UnpackFast(append(data, 0)); !errors.As(err, new(*ErrTrailingData)) {
+		t.Fatalf("UnpackFast with a trailing byte: got %v, expected *ErrTrailingData", err)
+	}
+
+}
+
+func TestUserGob(t *testing.T) {
+	var _ encoding.BinaryMarshaler = &User{}
+	var _ encoding.BinaryUnmarshaler = &User{}
+
+	in := binpackSampleUser()
+	buf := &bytes.Buffer{}
+	if err := gob.NewEncoder(buf).Encode(&in); err != nil {
+		t.Fatalf("gob Encode: %s", err)
+	}
+	out := User{}
+	if err := gob.NewDecoder(buf).Decode(&out); err != nil {
+		t.Fatalf("gob Decode: %s", err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("gob round trip mismatch\nin:  %#v\nout: %#v", in, out)
+	}
+}
+
+func TestUserUnpackTruncated(t *testing.T) {
+	in := binpackSampleUser()
+	data, err := in.Pack()
+	if err != nil {
+		t.Fatalf("Pack: %s", err)
+	}
+	for i := 0; i < len(data); i++ {
+		out := User{}
+		err := out.Unpack(data[:i])
+		if !errors.As(err, new(*ErrTruncated)) {
+			t.Fatalf("Unpack of %d bytes out of %d: got %v, expected *ErrTruncated", i, len(data), err)
+		}
+		if fastErr := out.UnpackFast(data[:i]); fmt.Sprint(fastErr) != fmt.Sprint(err) {
+			t.Fatalf("UnpackFast of %d bytes out of %d: got %v, expected %v", i, len(data), fastErr, err)
+		}
+
+	}
+}
+
+func TestUserFrames(t *testing.T) {
+	in := binpackSampleUser()
+	stream := &bytes.Buffer{}
+	fw := NewFrameWriter(stream)
+	for i := 0; i < 3; i++ {
+		if err := fw.Write(&in); err != nil {
+			t.Fatalf("FrameWriter.Write: %s", err)
+		}
+	}
+	n, err := in.WriteTo(stream)
+	if err != nil {
+		t.Fatalf("WriteTo: %s", err)
+	}
+	frame := stream.Bytes()[stream.Len()-int(n):]
+
+	// one byte reads check partial reads are completed
+	r := iotest.OneByteReader(bytes.NewReader(stream.Bytes()))
+	fr := NewFrameReader(r, 0)
+	for i := 0; i < 3; i++ {
+		out := User{}
+		if err := fr.Next(&out); err != nil {
+			t.Fatalf("FrameReader.Next of frame %d: %s", i, err)
+		}
+		if !reflect.DeepEqual(in, out) {
+			t.Fatalf("frame %d mismatch\nin:  %#v\nout: %#v", i, in, out)
+		}
+	}
+	out := User{}
+	if m, err := out.ReadFrom(r); err != nil || m != n || !reflect.DeepEqual(in, out) {
+		t.Fatalf("ReadFrom: read %d bytes of %d, error %v\nin:  %#v\nout: %#v", m, n, err, in, out)
+	}
+	if err := fr.Next(&out); err != io.EOF {
+		t.Fatalf("FrameReader.Next at the end: got %v, expected io.EOF", err)
+	}
+
+	for i := 1; i < len(frame); i++ {
+		if _, err := out.ReadFrom(bytes.NewReader(frame[:i])); err != io.ErrUnexpectedEOF {
+			t.Fatalf("ReadFrom of %d bytes out of %d: got %v, expected io.ErrUnexpectedEOF", i, len(frame), err)
+		}
+	}
+	if err := NewFrameReader(bytes.NewReader(frame), len(frame)-5).Next(&out); !errors.As(err, new(*ErrTooLong)) {
+		t.Fatalf("FrameReader.Next of a frame over max: got %v, expected *ErrTooLong", err)
+	}
+}
+
+func TestUserLoginTooLong(t *testing.T) {
+	in := binpackSampleUser()
+	in.Login = strings.Repeat("x", 65)
+	if _, err := in.Pack(); err == nil {
+		t.Fatal("Pack accepted Login longer than max")
+	}
+	out := User{}
+	if err := out.Unpack(in.AppendPack(nil)); !errors.As(err, new(*ErrTooLong)) {
+		t.Fatalf("Unpack: got %v, expected *ErrTooLong", err)
+	}
+	if err := out.UnpackFast(in.AppendPack(nil)); !errors.As(err, new(*ErrTooLong)) {
+		t.Fatalf("UnpackFast: got %v, expected *ErrTooLong", err)
+	}
+
+}
+
+func BenchmarkUserUnpack(b *testing.B) {
+	in := binpackSampleUser()
+	data, err := in.Pack()
+	if err != nil {
+		b.Fatalf("Pack: %s", err)
+	}
+	out := User{}
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := out.Unpack(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkUserUnpackFast(b *testing.B) {
+	in := binpackSampleUser()
+	data, err := in.Pack()
+	if err != nil {
+		b.Fatalf("Pack: %s", err)
+	}
+	out := User{}
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := out.UnpackFast(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func binpackExtremeUser() User {
+	return User{
+		ID:    math.MaxInt32,
+		Login: strings.Repeat("\xff", 64),
+		Flags: math.MaxInt32,
+	}
+}
+
+func FuzzUnpackUser(f *testing.F) {
+	for _, in := range []User{{}, binpackSampleUser(), binpackExtremeUser()} {
+		data, err := in.Pack()
+		if err != nil {
+			f.Fatalf("Pack of seed %#v: %s", in, err)
+		}
+		f.Add(data)
+		f.Add(data[:len(data)/2])
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		out := User{}
+		err := out.Unpack(data)
+		fast := User{}
+		if fastErr := fast.UnpackFast(data); fmt.Sprint(fastErr) != fmt.Sprint(err) {
+			t.Fatalf("UnpackFast: got %v, expected %v", fastErr, err)
+		}
+		if err != nil {
+			return
+		}
+
+		// compared packed, as NaN floats are not equal to themselves
+		packed, err := out.Pack()
+		if err != nil {
+			t.Fatalf("Pack of unpacked %#v: %s", out, err)
+		}
+		if size := out.PackedSize(); size != len(packed) {
+			t.Fatalf("PackedSize = %d, packed %d bytes", size, len(packed))
+		}
+		if fastPacked := fast.AppendPack(nil); !bytes.Equal(fastPacked, packed) {
+			t.Fatalf("UnpackFast result packs to %v, Unpack one to %v", fastPacked, packed)
+		}
+		again := User{}
+		if err := again.Unpack(packed); err != nil {
+			t.Fatalf("Unpack of packed %#v: %s", out, err)
+		}
+		if repacked := again.AppendPack(nil); !bytes.Equal(repacked, packed) {
+			t.Fatalf("round trip mismatch\npacked:   %v\nrepacked: %v", packed, repacked)
+		}
+	})
+}
+
+func binpackSampleSession() Session {
+	return Session{
+		User:    User{ID: 26, Login: "login-3", Flags: 52},
+		Token:   [16]byte{39, 52},
+		Expires: -33,
+		Admin:   true,
+		Scores:  []float32{6.25, 7.25},
+		Roles:   []string{"roles-7", "roles-8"},
+		Payload: []byte("payload-7"),
+		Device:  "device-8",
+	}
+}
+
+func TestSessionPackRoundTrip(t *testing.T) {
+	in := binpackSampleSession()
+
+	data, err := in.Pack()
+	if err != nil {
+		t.Fatalf("Pack: %s", err)
+	}
+	if appended := in.AppendPack([]byte{0xff}); !bytes.Equal(appended[1:], data) || appended[0] != 0xff {
+		t.Fatalf("AppendPack = %v, expected 0xff followed by %v", appended, data)
+	}
+	if size := in.PackedSize(); size != len(data) || cap(data) != len(data) {
+		t.Fatalf("PackedSize = %d, packed %d bytes with capacity %d", size, len(data), cap(data))
+	}
+
+	out := Session{}
+	if err := out.Unpack(data); err != nil {
+		t.Fatalf("Unpack: %s", err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("round trip mismatch\nin:  %#v\nout: %#v", in, out)
+	}
+
+	fast := Session{}
+	if err := fast.UnpackFast(data); err != nil {
+		t.Fatalf("UnpackFast: %s", err)
+	}
+	if !reflect.DeepEqual(in, fast) {
+		t.Fatalf("UnpackFast round trip mismatch\nin:  %#v\nout: %#v", in, fast)
+	}
+
+}
+
+func TestSessionGob(t *testing.T) {
+	var _ encoding.BinaryMarshaler = &Session{}
+	var _ encoding.BinaryUnmarshaler = &Session{}
+
+	in := binpackSampleSession()
+	buf := &bytes.Buffer{}
+	if err := gob.NewEncoder(buf).Encode(&in); err != nil {
+		t.Fatalf("gob Encode: %s", err)
+	}
+	out := Session{}
+	if err := gob.NewDecoder(buf).Decode(&out); err != nil {
+		t.Fatalf("gob Decode: %s", err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("gob round trip mismatch\nin:  %#v\nout: %#v", in, out)
+	}
+}
+
+func TestSessionUnpackTruncated(t *testing.T) {
+	in := binpackSampleSession()
+	data, err := in.Pack()
+	if err != nil {
+		t.Fatalf("Pack: %s", err)
+	}
+	for i := 0; i < len(data); i++ {
+		out := Session{}
+		err := out.Unpack(data[:i])
+		if !errors.As(err, new(*ErrTruncated)) {
+			t.Fatalf("Unpack of %d bytes out of %d: got %v, expected *ErrTruncated", i, len(data), err)
+		}
+		if fastErr := out.UnpackFast(data[:i]); fmt.Sprint(fastErr) != fmt.Sprint(err) {
+			t.Fatalf("UnpackFast of %d bytes out of %d: got %v, expected %v", i, len(data), fastErr, err)
+		}
+
+	}
+}
+
+func TestSessionFrames(t *testing.T) {
+	in := binpackSampleSession()
+	stream := &bytes.Buffer{}
+	fw := NewFrameWriter(stream)
+	for i := 0; i < 3; i++ {
+		if err := fw.Write(&in); err != nil {
+			t.Fatalf("FrameWriter.Write: %s", err)
+		}
+	}
+	n, err := in.WriteTo(stream)
+	if err != nil {
+		t.Fatalf("WriteTo: %s", err)
+	}
+	frame := stream.Bytes()[stream.Len()-int(n):]
+
+	// one byte reads check partial reads are completed
+	r := iotest.OneByteReader(bytes.NewReader(stream.Bytes()))
+	fr := NewFrameReader(r, 0)
+	for i := 0; i < 3; i++ {
+		out := Session{}
+		if err := fr.Next(&out); err != nil {
+			t.Fatalf("FrameReader.Next of frame %d: %s", i, err)
+		}
+		if !reflect.DeepEqual(in, out) {
+			t.Fatalf("frame %d mismatch\nin:  %#v\nout: %#v", i, in, out)
+		}
+	}
+	out := Session{}
+	if m, err := out.ReadFrom(r); err != nil || m != n || !reflect.DeepEqual(in, out) {
+		t.Fatalf("ReadFrom: read %d bytes of %d, error %v\nin:  %#v\nout: %#v", m, n, err, in, out)
+	}
+	if err := fr.Next(&out); err != io.EOF {
+		t.Fatalf("FrameReader.Next at the end: got %v, expected io.EOF", err)
+	}
+
+	for i := 1; i < len(frame); i++ {
+		if _, err := out.ReadFrom(bytes.NewReader(frame[:i])); err != io.ErrUnexpectedEOF {
+			t.Fatalf("ReadFrom of %d bytes out of %d: got %v, expected io.ErrUnexpectedEOF", i, len(frame), err)
+		}
+	}
+	if err := NewFrameReader(bytes.NewReader(frame), len(frame)-5).Next(&out); !errors.As(err, new(*ErrTooLong)) {
+		t.Fatalf("FrameReader.Next of a frame over max: got %v, expected *ErrTooLong", err)
+	}
+}
+
+func TestSessionChecksum(t *testing.T) {
+	in := binpackSampleSession()
+	data, err := in.Pack()
+	if err != nil {
+		t.Fatalf("Pack: %s", err)
+	}
+	checksums := 0
+	// a changed version is a valid record of another version
+	for i := 2; i < len(data); i++ {
+		bad := append([]byte(nil), data...)
+		bad[i] ^= 0x10
+		out := Session{}
+		err := out.Unpack(bad)
+		if err == nil && reflect.DeepEqual(in, out) {
+			t.Fatalf("Unpack with a flipped bit in byte %d returned the packed value", i)
+		}
+		if errors.As(err, new(*ErrChecksum)) {
+			checksums++
+		}
+		if fastErr := out.UnpackFast(bad); fmt.Sprint(fastErr) != fmt.Sprint(err) {
+			t.Fatalf("UnpackFast with a flipped bit in byte %d: got %v, expected %v", i, fastErr, err)
+		}
+
+	}
+	if checksums == 0 {
+		t.Fatal("no flipped bit was reported as *ErrChecksum")
+	}
+}
+
+func TestSessionVersions(t *testing.T) {
+	in := binpackSampleSession()
+	for version := 1; version < 2; version++ {
+		expected := in
+		if version < 2 {
+			expected.Device = ""
+		}
+
+		// decoded into a filled value, fields missing in old records must be reset
+		out := binpackSampleSession()
+		if err := out.Unpack(in.binpackAppendVersion(nil, version)); err != nil {
+			t.Fatalf("Unpack of version %d: %s", version, err)
+		}
+		if !reflect.DeepEqual(expected, out) {
+			t.Fatalf("version %d mismatch\nexpected: %#v\nout:      %#v", version, expected, out)
+		}
+		fast := binpackSampleSession()
+		if err := fast.UnpackFast(in.binpackAppendVersion(nil, version)); err != nil || !reflect.DeepEqual(expected, fast) {
+			t.Fatalf("UnpackFast of version %d: error %v\nexpected: %#v\nout:      %#v", version, err, expected, fast)
+		}
+
+	}
+
+	// a record of a newer version with a field unknown yet
+	data := in.AppendPack(nil)
+	data = append(data, 1, 2, 3)
+	binary.LittleEndian.PutUint16(data, 2+1)
+	binary.LittleEndian.PutUint32(data[2:], uint32(len(data)-6))
+	out := Session{}
+	if err := out.Unpack(data); err != nil || !reflect.DeepEqual(in, out) {
+		t.Fatalf("Unpack of a newer version: error %v\nin:  %#v\nout: %#v", err, in, out)
+	}
+	if err := out.UnpackFast(data); err != nil || !reflect.DeepEqual(in, out) {
+		t.Fatalf("UnpackFast of a newer version: error %v\nin:  %#v\nout: %#v", err, in, out)
+	}
+
+	// the same bytes are corrupted data for the current version
+	binary.LittleEndian.PutUint16(data, 2)
+	if err := out.Unpack(data); !errors.As(err, new(*ErrTrailingData)) {
+		t.Fatalf("Unpack of a longer record: got %v, expected *ErrTrailingData", err)
+	}
+}
+
+func TestSessionRolesTooLong(t *testing.T) {
+	in := binpackSampleSession()
+	in.Roles = make([]string, 17)
+	if _, err := in.Pack(); err == nil {
+		t.Fatal("Pack accepted Roles longer than max")
+	}
+	out := Session{}
+	if err := out.Unpack(in.AppendPack(nil)); !errors.As(err, new(*ErrTooLong)) {
+		t.Fatalf("Unpack: got %v, expected *ErrTooLong", err)
+	}
+	if err := out.UnpackFast(in.AppendPack(nil)); !errors.As(err, new(*ErrTooLong)) {
+		t.Fatalf("UnpackFast: got %v, expected *ErrTooLong", err)
+	}
+
+}
+
+func TestSessionDeviceTooLong(t *testing.T) {
+	in := binpackSampleSession()
+	in.Device = strings.Repeat("x", 33)
+	if _, err := in.Pack(); err == nil {
+		t.Fatal("Pack accepted Device longer than max")
+	}
+	out := Session{}
+	if err := out.Unpack(in.AppendPack(nil)); !errors.As(err, new(*ErrTooLong)) {
+		t.Fatalf("Unpack: got %v, expected *ErrTooLong", err)
+	}
+	if err := out.UnpackFast(in.AppendPack(nil)); !errors.As(err, new(*ErrTooLong)) {
+		t.Fatalf("UnpackFast: got %v, expected *ErrTooLong", err)
+	}
+
+}
+
+func BenchmarkSessionUnpack(b *testing.B) {
+	in := binpackSampleSession()
+	data, err := in.Pack()
+	if err != nil {
+		b.Fatalf("Pack: %s", err)
+	}
+	out := Session{}
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := out.Unpack(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSessionUnpackFast(b *testing.B) {
+	in := binpackSampleSession()
+	data, err := in.Pack()
+	if err != nil {
+		b.Fatalf("Pack: %s", err)
+	}
+	out := Session{}
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := out.UnpackFast(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func binpackExtremeSession() Session {
+	return Session{
+		User:    User{ID: math.MaxInt32, Login: strings.Repeat("\xff", 64), Flags: math.MaxInt32},
+		Token:   [16]byte{math.MaxUint8},
+		Expires: math.MinInt64,
+		Admin:   true,
+		Scores:  []float32{math.MaxFloat32},
+		Roles:   []string{"\xff"},
+		Payload: []byte{0xff},
+		Device:  strings.Repeat("\xff", 32),
+	}
+}
+
+func FuzzUnpackSession(f *testing.F) {
+	for _, in := range []Session{{}, binpackSampleSession(), binpackExtremeSession()} {
+		data, err := in.Pack()
+		if err != nil {
+			f.Fatalf("Pack of seed %#v: %s", in, err)
+		}
+		f.Add(data)
+		f.Add(data[:len(data)/2])
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		out := Session{}
+		err := out.Unpack(data)
+		fast := Session{}
+		if fastErr := fast.UnpackFast(data); fmt.Sprint(fastErr) != fmt.Sprint(err) {
+			t.Fatalf("UnpackFast: got %v, expected %v", fastErr, err)
+		}
+		if err != nil {
+			return
+		}
+
+		// compared packed, as NaN floats are not equal to themselves
+		packed, err := out.Pack()
+		if err != nil {
+			t.Fatalf("Pack of unpacked %#v: %s", out, err)
+		}
+		if size := out.PackedSize(); size != len(packed) {
+			t.Fatalf("PackedSize = %d, packed %d bytes", size, len(packed))
+		}
+		if fastPacked := fast.AppendPack(nil); !bytes.Equal(fastPacked, packed) {
+			t.Fatalf("UnpackFast result packs to %v, Unpack one to %v", fastPacked, packed)
+		}
+		again := Session{}
+		if err := again.Unpack(packed); err != nil {
+			t.Fatalf("Unpack of packed %#v: %s", out, err)
+		}
+		if repacked := again.AppendPack(nil); !bytes.Equal(repacked, packed) {
+			t.Fatalf("round trip mismatch\npacked:   %v\nrepacked: %v", packed, repacked)
+		}
+	})
+}
+
+func binpackSampleHeader() Header {
+	return Header{
+		Seq:      13,
+		Kind:     26,
+		Host:     "host-3",
+		Path:     "path-4",
+		Offsets:  []int64{-66, -77},
+		Checksum: 78,
+		Sent:     *new(time.Time),
+	}
+}
+
+func TestHeaderPackRoundTrip(t *testing.T) {
+	in := binpackSampleHeader()
+
+	data, err := in.Pack()
+	if err != nil {
+		t.Fatalf("Pack: %s", err)
+	}
+	if appended := in.AppendPack([]byte{0xff}); !bytes.Equal(appended[1:], data) || appended[0] != 0xff {
+		t.Fatalf("AppendPack = %v, expected 0xff followed by %v", appended, data)
+	}
+	if size := in.PackedSize(); size != len(data) || cap(data) != len(data) {
+		t.Fatalf("PackedSize = %d, packed %d bytes with capacity %d", size, len(data), cap(data))
+	}
+
+	out := Header{}
+	if err := out.Unpack(data); err != nil {
+		t.Fatalf("Unpack: %s", err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("round trip mismatch\nin:  %#v\nout: %#v", in, out)
+	}
+
+	fast := Header{}
+	if err := fast.UnpackFast(data); err != nil {
+		t.Fatalf("UnpackFast: %s", err)
+	}
+	if !reflect.DeepEqual(in, fast) {
+		t.Fatalf("UnpackFast round trip mismatch\nin:  %#v\nout: %#v", in, fast)
+	}
+
+}
+
+func TestHeaderGob(t *testing.T) {
+	var _ encoding.BinaryMarshaler = &Header{}
+	var _ encoding.BinaryUnmarshaler = &Header{}
+
+	in := binpackSampleHeader()
+	buf := &bytes.Buffer{}
+	if err := gob.NewEncoder(buf).Encode(&in); err != nil {
+		t.Fatalf("gob Encode: %s", err)
+	}
+	out := Header{}
+	if err := gob.NewDecoder(buf).Decode(&out); err != nil {
+		t.Fatalf("gob Decode: %s", err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("gob round trip mismatch\nin:  %#v\nout: %#v", in, out)
+	}
+}
+
+func TestHeaderUnpackTruncated(t *testing.T) {
+	in := binpackSampleHeader()
+	data, err := in.Pack()
+	if err != nil {
+		t.Fatalf("Pack: %s", err)
+	}
+	for i := 0; i < len(data); i++ {
+		out := Header{}
+		err := out.Unpack(data[:i])
+		if !errors.As(err, new(*ErrTruncated)) {
+			t.Fatalf("Unpack of %d bytes out of %d: got %v, expected *ErrTruncated", i, len(data), err)
+		}
+		if fastErr := out.UnpackFast(data[:i]); fmt.Sprint(fastErr) != fmt.Sprint(err) {
+			t.Fatalf("UnpackFast of %d bytes out of %d: got %v, expected %v", i, len(data), fastErr, err)
+		}
+
+	}
+}
+
+func TestHeaderFrames(t *testing.T) {
+	in := binpackSampleHeader()
+	stream := &bytes.Buffer{}
+	fw := NewFrameWriter(stream)
+	for i := 0; i < 3; i++ {
+		if err := fw.Write(&in); err != nil {
+			t.Fatalf("FrameWriter.Write: %s", err)
+		}
+	}
+	n, err := in.WriteTo(stream)
+	if err != nil {
+		t.Fatalf("WriteTo: %s", err)
+	}
+	frame := stream.Bytes()[stream.Len()-int(n):]
+
+	// one byte reads check partial reads are completed
+	r := iotest.OneByteReader(bytes.NewReader(stream.Bytes()))
+	fr := NewFrameReader(r, 0)
+	for i := 0; i < 3; i++ {
+		out := Header{}
+		if err := fr.Next(&out); err != nil {
+			t.Fatalf("FrameReader.Next of frame %d: %s", i, err)
+		}
+		if !reflect.DeepEqual(in, out) {
+			t.Fatalf("frame %d mismatch\nin:  %#v\nout: %#v", i, in, out)
+		}
+	}
+	out := Header{}
+	if m, err := out.ReadFrom(r); err != nil || m != n || !reflect.DeepEqual(in, out) {
+		t.Fatalf("ReadFrom: read %d bytes of %d, error %v\nin:  %#v\nout: %#v", m, n, err, in, out)
+	}
+	if err := fr.Next(&out); err != io.EOF {
+		t.Fatalf("FrameReader.Next at the end: got %v, expected io.EOF", err)
+	}
+
+	for i := 1; i < len(frame); i++ {
+		if _, err := out.ReadFrom(bytes.NewReader(frame[:i])); err != io.ErrUnexpectedEOF {
+			t.Fatalf("ReadFrom of %d bytes out of %d: got %v, expected io.ErrUnexpectedEOF", i, len(frame), err)
+		}
+	}
+	if err := NewFrameReader(bytes.NewReader(frame), len(frame)-5).Next(&out); !errors.As(err, new(*ErrTooLong)) {
+		t.Fatalf("FrameReader.Next of a frame over max: got %v, expected *ErrTooLong", err)
+	}
+}
+
+func TestHeaderChecksum(t *testing.T) {
+	in := binpackSampleHeader()
+	data, err := in.Pack()
+	if err != nil {
+		t.Fatalf("Pack: %s", err)
+	}
+	checksums := 0
+	for i := 0; i < len(data); i++ {
+		bad := append([]byte(nil), data...)
+		bad[i] ^= 0x10
+		out := Header{}
+		err := out.Unpack(bad)
+		if err == nil && reflect.DeepEqual(in, out) {
+			t.Fatalf("Unpack with a flipped bit in byte %d returned the packed value", i)
+		}
+		if errors.As(err, new(*ErrChecksum)) {
+			checksums++
+		}
+		if fastErr := out.UnpackFast(bad); fmt.Sprint(fastErr) != fmt.Sprint(err) {
+			t.Fatalf("UnpackFast with a flipped bit in byte %d: got %v, expected %v", i, fastErr, err)
+		}
+
+	}
+	if checksums == 0 {
+		t.Fatal("no flipped bit was reported as *ErrChecksum")
+	}
+}
+
+func TestHeaderHostTooLong(t *testing.T) {
+	in := binpackSampleHeader()
+	in.Host = strings.Repeat("x", 254)
+	if _, err := in.Pack(); err == nil {
+		t.Fatal("Pack accepted Host longer than max")
+	}
+	out := Header{}
+	if err := out.Unpack(in.AppendPack(nil)); !errors.As(err, new(*ErrTooLong)) {
+		t.Fatalf("Unpack: got %v, expected *ErrTooLong", err)
+	}
+	if err := out.UnpackFast(in.AppendPack(nil)); !errors.As(err, new(*ErrTooLong)) {
+		t.Fatalf("UnpackFast: got %v, expected *ErrTooLong", err)
+	}
+
+}
+
+func BenchmarkHeaderUnpack(b *testing.B) {
+	in := binpackSampleHeader()
+	data, err := in.Pack()
+	if err != nil {
+		b.Fatalf("Pack: %s", err)
+	}
+	out := Header{}
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := out.Unpack(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkHeaderUnpackFast(b *testing.B) {
+	in := binpackSampleHeader()
+	data, err := in.Pack()
+	if err != nil {
+		b.Fatalf("Pack: %s", err)
+	}
+	out := Header{}
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := out.UnpackFast(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func binpackExtremeHeader() Header {
+	return Header{
+		Seq:      math.MaxInt32,
+		Kind:     math.MaxUint16,
+		Host:     strings.Repeat("\xff", 253),
+		Path:     "\xff",
+		Offsets:  []int64{math.MinInt64},
+		Checksum: math.MaxUint32,
+		Sent:     *new(time.Time),
+	}
+}
+
+func FuzzUnpackHeader(f *testing.F) {
+	for _, in := range []Header{{}, binpackSampleHeader(), binpackExtremeHeader()} {
+		data, err := in.Pack()
+		if err != nil {
+			f.Fatalf("Pack of seed %#v: %s", in, err)
+		}
+		f.Add(data)
+		f.Add(data[:len(data)/2])
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		out := Header{}
+		err := out.Unpack(data)
+		fast := Header{}
+		if fastErr := fast.UnpackFast(data); fmt.Sprint(fastErr) != fmt.Sprint(err) {
+			t.Fatalf("UnpackFast: got %v, expected %v", fastErr, err)
+		}
+		if err != nil {
+			return
+		}
+
+		// compared packed, as NaN floats are not equal to themselves
+		packed, err := out.Pack()
+		if err != nil {
+			t.Fatalf("Pack of unpacked %#v: %s", out, err)
+		}
+		if size := out.PackedSize(); size != len(packed) {
+			t.Fatalf("PackedSize = %d, packed %d bytes", size, len(packed))
+		}
+		if fastPacked := fast.AppendPack(nil); !bytes.Equal(fastPacked, packed) {
+			t.Fatalf("UnpackFast result packs to %v, Unpack one to %v", fastPacked, packed)
+		}
+		again := Header{}
+		if err := again.Unpack(packed); err != nil {
+			t.Fatalf("Unpack of packed %#v: %s", out, err)
+		}
+		if repacked := again.AppendPack(nil); !bytes.Equal(repacked, packed) {
+			t.Fatalf("round trip mismatch\npacked:   %v\nrepacked: %v", packed, repacked)
+		}
+	})
+}
@@ -1,17 +1,49 @@
-// go build gen/* && ./codegen.exe pack/packer.go  pack/marshaller.go
+// go build gen/* && ./codegen.exe pack/unpack.go  pack/marshaller.go
+// go run ./pack
 package main
 
-import "fmt"
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+)
 
 // lets generate code for this struct
-// cgen: binpack
+// cgen: binpack strict fast "L L/a* L"
 type User struct {
 	ID       int
 	RealName string `cgen:"-"`
-	Login    string
+	Login    string `cgen:"max=64,alias"`
 	Flags    int
 }
 
+// Device was added in the second version, sessions packed before are still read
+// cgen: binpack fast version=2 crc=ieee
+type Session struct {
+	User    User
+	Token   [16]byte
+	Expires int64
+	Admin   bool
+	Scores  []float32
+	Roles   []string `cgen:"max=16"`
+	Payload []byte
+	Device  string `cgen:"since=2,max=32"`
+}
+
+// network byte order, varints are Go ones, not perl w, the crc covers only Seq and Kind,
+// Sent is packed by its MarshalBinary
+// cgen: binpack be fast crc=castagnoli:Kind
+type Header struct {
+	Seq      int `cgen:"u32"`
+	Kind     uint16
+	Host     string    `cgen:"len=u8,max=253"`
+	Path     string    `cgen:"len=varint"`
+	Offsets  []int64   `cgen:"varint,len=u16"`
+	Checksum uint32    `cgen:"le"`
+	Sent     time.Time `cgen:"len=u8"`
+}
+
 type Avatar struct {
 	ID  int
 	Url string
@@ -35,5 +67,36 @@ func main() {
 
 	u := User{}
 	u.Unpack(data)
-	fmt.Printf("Unpacked user %#v", u)
+	fmt.Printf("Unpacked user %#v\n", u)
+
+	packed, err := u.Pack()
+	if err != nil {
+		fmt.Println("pack error:", err)
+		return
+	}
+	fmt.Printf("Packed back, same as data: %v\n", bytes.Equal(packed, data))
+
+	// records are streamed as length prefixed frames
+	pr, pw := io.Pipe()
+	go func() {
+		fw := NewFrameWriter(pw)
+		for i := 0; i < 3; i++ {
+			u.ID++
+			fw.Write(&u)
+		}
+		pw.Close()
+	}()
+	fr := NewFrameReader(pr, 1024)
+	for {
+		next := User{}
+		err := fr.Next(&next)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fmt.Println("read error:", err)
+			return
+		}
+		fmt.Printf("Read user from pipe %#v\n", next)
+	}
 }
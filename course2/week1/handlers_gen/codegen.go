@@ -1,26 +1,59 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"go/ast"
+	"go/format"
 	"go/parser"
 	"go/token"
+	"io/ioutil"
 	"log"
+	"net/http"
 	"os"
-	"reflect"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
-	"text/template"
+	"time"
 )
 
 // код писать тут
 
+var (
+	withMetrics = flag.Bool("metrics", false, "record per-endpoint metrics and generate MetricsHandler")
+	checkOnly   = flag.Bool("check", false, "do not write the output file, exit with non-zero code if it differs from the generated code")
+	tsOut       = flag.String("ts", "", "also write TypeScript types and a fetch client to this file")
+	cliOut      = flag.String("cli", "", "also write a command line client main package to this file")
+	cliApi      = flag.String("cli-api", "", "struct to generate the command line client for, all of them with commands prefixed by the struct name if empty")
+	mocksOut    = flag.String("mocks", "", "also write service interfaces, mocks and httptest servers to this file, e.g. api_mocks_test.go")
+	testsOut    = flag.String("tests", "", "also write validation tests of the annotated methods to this file, requires -mocks")
+	tplDir      = flag.String("templates", "", "directory with *.tmpl files overriding the default templates")
+	messagesIn  = flag.String("messages", "", "JSON file with validation messages by locale and rule, merged over the built-in en and ru ones")
+)
+
+// codegenParams is the JSON of an apigen:api annotation.
 type codegenParams struct {
-	Url      string `json:"url"`
-	Auth     bool   `json:"auth"`
-	Method   string `json:"method"`
-	FuncName string `json:"-"`
+	Url        string `json:"url"`
+	Auth       bool   `json:"auth"`
+	Method     string `json:"method"`
+	Etag       bool   `json:"etag"`
+	MaxAge     int    `json:"max_age"`
+	Idempotent bool   `json:"idempotent"`
+	MaxMemory  string `json:"max_memory"`
+	Deprecated string `json:"deprecated"`
+	Version    string `json:"version"`
+	FuncName   string `json:"-"`
+}
+
+// Path returns the url the handler is mounted at, prefixed with the version if any.
+func (cp *codegenParams) Path() string {
+	if cp.Version == "" {
+		return cp.Url
+	}
+	return "/" + cp.Version + cp.Url
 }
 
 func newCodegenParamsFromJSON(b []byte) (*codegenParams, error) {
@@ -33,18 +66,34 @@ func newCodegenParamsFromJSON(b []byte) (*codegenParams, error) {
 
 type serveHTTPMethodsHub map[string][]*codegenParams
 
-func (h serveHTTPMethodsHub) AddHandlerForStruct(sn string, cp *codegenParams) {
+func (h serveHTTPMethodsHub) AddHandlerForStruct(sn string, cp *codegenParams) error {
 	if _, ok := h[sn]; !ok {
 		h[sn] = make([]*codegenParams, 0, 1)
 	}
+	for _, other := range h[sn] {
+		if other.Path() == cp.Path() {
+			return fmt.Errorf("url %s is already handled by %s", cp.Path(), other.FuncName)
+		}
+	}
 	h[sn] = append(h[sn], cp)
+	return nil
+}
+
+// StructNames returns struct names in a stable order so the generated code does not change between runs.
+func (h serveHTTPMethodsHub) StructNames() []string {
+	res := make([]string, 0, len(h))
+	for sn := range h {
+		res = append(res, sn)
+	}
+	sort.Strings(res)
+	return res
 }
 
 func (h serveHTTPMethodsHub) String() string {
 	res := "{ "
-	for sn, cps := range h {
+	for _, sn := range h.StructNames() {
 		res += sn + " : ["
-		for _, cp := range cps {
+		for _, cp := range h[sn] {
 			res += fmt.Sprintf("%+v, ", cp)
 		}
 		res += "] "
@@ -55,19 +104,236 @@ func (h serveHTTPMethodsHub) String() string {
 
 const apiGenPrefix = "// apigen:api "
 
+const corsGenPrefix = "// apigen:cors "
+
+// corsParams is the JSON of an apigen:cors annotation, available to httpTpl as .Cors.
+type corsParams struct {
+	Origins     []string `json:"origins"`
+	Methods     []string `json:"methods"`
+	Headers     []string `json:"headers"`
+	Expose      []string `json:"expose"`
+	Credentials bool     `json:"credentials"`
+	MaxAge      int      `json:"max_age"`
+}
+
+func newCorsParamsFromJSON(b []byte) (*corsParams, error) {
+	c := &corsParams{}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, err
+	}
+	if c.Credentials && c.AnyOrigin() {
+		// any site could make credentialed requests, browsers forbid "*" with credentials for the same reason
+		return c, fmt.Errorf(`"credentials" can not be used with "*" in "origins", list the allowed origins`)
+	}
+	return c, nil
+}
+
+// AllowMethods returns the methods from the annotation or the ones used by the struct endpoints.
+func (c *corsParams) AllowMethods(cps []*codegenParams) string {
+	if len(c.Methods) > 0 {
+		return strings.Join(c.Methods, ", ")
+	}
+	methods := make(map[string]bool)
+	for _, cp := range cps {
+		if cp.Method == "" {
+			methods["GET"] = true
+			methods["POST"] = true
+			continue
+		}
+		methods[cp.Method] = true
+	}
+	res := make([]string, 0, len(methods))
+	for m := range methods {
+		res = append(res, m)
+	}
+	sort.Strings(res)
+	return strings.Join(res, ", ")
+}
+
+func (c *corsParams) AnyOrigin() bool {
+	for _, o := range c.Origins {
+		if o == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+type importSet map[string]bool
+
+func newImportSet(paths ...string) importSet {
+	s := make(importSet)
+	s.Add(paths...)
+	return s
+}
+
+func (s importSet) Add(paths ...string) {
+	for _, p := range paths {
+		s[p] = true
+	}
+}
+
+func (s importSet) Sorted() []string {
+	res := make([]string, 0, len(s))
+	for p := range s {
+		res = append(res, p)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// validateParams describes a params struct field tagged with apivalidator.
+// GetValueFromRequest and GetValidation render the binding and validation code of the field.
 type validateParams struct {
-	FieldName string
-	FieldType string
-	Required  bool
-	ParamName string
-	Enum      []string
-	Default   string
-	Min       *int64
-	Max       int64
+	FieldName  string
+	FieldType  string
+	Required   bool
+	ParamName  string
+	Enum       []string
+	Default    string
+	Min        *int64
+	Max        int64
+	MaxSize    int64
+	MaxSizeRaw string
+	Mime       []string
+}
+
+// IsFile reports whether the field is bound from a multipart file.
+func (vp *validateParams) IsFile() bool {
+	return vp.FieldType == "*multipart.FileHeader" || vp.FieldType == "[]byte"
+}
+
+// validationMessages is the catalog of validation error messages keyed by locale and rule.
+// {param} is replaced with the request param name, {value} with the rule argument.
+var validationMessages = map[string]map[string]string{
+	"en": {
+		"required":  "{param} must me not empty",
+		"int":       "{param} must be int",
+		"enum":      "{param} must be one of [{value}]",
+		"min":       "{param} must be >= {value}",
+		"min_len":   "{param} len must be >= {value}",
+		"max":       "{param} must be <= {value}",
+		"max_size":  "{param} size must be <= {value}",
+		"mime":      "{param} must be one of types [{value}]",
+		"file":      "{param} can not be read",
+		"multipart": "bad multipart body",
+		"body_size": "request body size must be <= {value} bytes",
+	},
+	"ru": {
+		"required":  "{param} не должен быть пустым",
+		"int":       "{param} должен быть целым числом",
+		"enum":      "{param} должен быть одним из [{value}]",
+		"min":       "{param} должен быть >= {value}",
+		"min_len":   "длина {param} должна быть >= {value}",
+		"max":       "{param} должен быть <= {value}",
+		"max_size":  "размер {param} должен быть <= {value}",
+		"mime":      "{param} должен иметь один из типов [{value}]",
+		"file":      "не удалось прочитать {param}",
+		"multipart": "некорректное multipart тело запроса",
+		"body_size": "размер тела запроса должен быть <= {value} байт",
+	},
+}
+
+const defaultLocale = "en"
+
+// loadValidationMessages merges the catalog from a JSON file of the same shape as
+// validationMessages over it, so locales are added without changes to the generator.
+func loadValidationMessages(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	catalog := make(map[string]map[string]string)
+	if err := json.Unmarshal(b, &catalog); err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	for locale, messages := range catalog {
+		if locale != strings.ToLower(locale) || strings.Contains(locale, "-") {
+			return fmt.Errorf("%s: locale %q must be a lower case language without a region, as Accept-Language tags are matched by it", path, locale)
+		}
+		for rule := range messages {
+			if _, ok := validationMessages[defaultLocale][rule]; !ok {
+				return fmt.Errorf("%s: %s: unknown rule %q", path, locale, rule)
+			}
+		}
+		if validationMessages[locale] == nil {
+			validationMessages[locale] = make(map[string]string)
+		}
+		for rule, msg := range messages {
+			validationMessages[locale][rule] = msg
+		}
+	}
+	return nil
+}
+
+func (vp *validateParams) validationError(rule, value string) string {
+	return validationErrorCode("http.StatusBadRequest", rule, vp.ParamName, value)
+}
+
+func validationErrorCode(status, rule, param, value string) string {
+	res := ""
+	if *withMetrics {
+		res = `outcome = "validation"
+		`
+	}
+	return res + `w.WriteHeader(` + status + `)
+		_, _ = w.Write(apiErrorBody(apiValidationMessage(r, ` + strconv.Quote(rule) + `, ` + strconv.Quote(param) + `, ` + strconv.Quote(value) + `)))
+		return`
+}
+
+func (vp *validateParams) getFileValidation() string {
+	fhVarName := "fh" + vp.FieldName
+	rawVarName := "raw" + vp.FieldName
+	res := ""
+	if vp.Required {
+		res += `if ` + fhVarName + ` == nil {
+		` + vp.validationError("required", "") + `
+	}
+`
+	}
+	if vp.MaxSize > 0 {
+		res += `
+	if ` + fhVarName + ` != nil && ` + fhVarName + `.Size > ` + strconv.FormatInt(vp.MaxSize, 10) + ` {
+		` + validationErrorCode("http.StatusRequestEntityTooLarge", "max_size", vp.ParamName, vp.MaxSizeRaw) + `
+	}
+`
+	}
+	if len(vp.Mime) > 0 {
+		types := make([]string, len(vp.Mime))
+		for i, m := range vp.Mime {
+			types[i] = strconv.Quote(m)
+		}
+		res += `
+	if ` + fhVarName + ` != nil && !apiFileMimeIn(` + fhVarName + `, ` + strings.Join(types, ", ") + `) {
+		` + vp.validationError("mime", strings.Join(vp.Mime, ", ")) + `
+	}
+`
+	}
+	if vp.FieldType == "[]byte" {
+		res += `
+	var ` + rawVarName + ` []byte
+	if ` + fhVarName + ` != nil {
+		b, err := apiReadFile(` + fhVarName + `)
+		if err != nil {
+			` + vp.validationError("file", "") + `
+		}
+		` + rawVarName + ` = b
+	}
+`
+	} else {
+		res += `
+	` + rawVarName + ` := ` + fhVarName + `
+`
+	}
+	return res
 }
 
 func (vp *validateParams) GetValueFromRequest(httpMethod string) string {
-	res := "r.FormValue(\"" + vp.ParamName + "\")"
+	if vp.IsFile() {
+		return "fh" + vp.FieldName + " := apiFormFile(r, " + strconv.Quote(vp.ParamName) + ")"
+	}
+
+	res := "r.FormValue(" + strconv.Quote(vp.ParamName) + ")"
 
 	rawVarName := "raw" + vp.FieldName
 
@@ -76,10 +342,7 @@ func (vp *validateParams) GetValueFromRequest(httpMethod string) string {
 		res = rawVarName + ", err := strconv.Atoi(" + res + ")"
 		res = res + `
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		rb, _ := json.Marshal(&ResponseEnvelope{"` + vp.ParamName + ` must be int",nil})
-		_, _ = w.Write(rb)
-		return
+		` + vp.validationError("int", "") + `
 	}
 `
 	case "string":
@@ -89,24 +352,22 @@ func (vp *validateParams) GetValueFromRequest(httpMethod string) string {
 }
 
 func (vp *validateParams) GetValidation() string {
+	if vp.IsFile() {
+		return vp.getFileValidation()
+	}
+
 	res := ""
 	rawVarName := "raw" + vp.FieldName
 	if vp.Required {
 		switch vp.FieldType {
 		case "int":
 			res = `if ` + rawVarName + ` == 0 {
-		w.WriteHeader(http.StatusBadRequest)
-		rb, _ := json.Marshal(&ResponseEnvelope{"` + vp.ParamName + ` must me not empty",nil})
-		_, _ = w.Write(rb)
-		return
+		` + vp.validationError("required", "") + `
 	}
 `
 		case "string":
 			res = `if len(` + rawVarName + `) < 1 {
-		w.WriteHeader(http.StatusBadRequest)
-		rb, _ := json.Marshal(&ResponseEnvelope{"` + vp.ParamName + ` must me not empty",nil})
-		_, _ = w.Write(rb)
-		return
+		` + vp.validationError("required", "") + `
 	}
 `
 		}
@@ -126,7 +387,7 @@ func (vp *validateParams) GetValidation() string {
 		case "string":
 			res += `
 	if len(` + rawVarName + `) < 1 {
-		` + rawVarName + ` = "` + vp.Default + `"
+		` + rawVarName + ` = ` + strconv.Quote(vp.Default) + `
 	}
 `
 		}
@@ -138,14 +399,11 @@ func (vp *validateParams) GetValidation() string {
 			if i > 0 {
 				cond += " && "
 			}
-			cond += rawVarName + ` != "` + v + `"`
+			cond += rawVarName + ` != ` + strconv.Quote(v)
 		}
 		res += `
 	if ` + cond + ` {
-		w.WriteHeader(http.StatusBadRequest)
-		rb, _ := json.Marshal(&ResponseEnvelope{"` + vp.ParamName + ` must be one of [` + strings.Join(vp.Enum, ", ") + `]",nil})
-		_, _ = w.Write(rb)
-		return
+		` + vp.validationError("enum", strings.Join(vp.Enum, ", ")) + `
 	}
 `
 	}
@@ -155,19 +413,13 @@ func (vp *validateParams) GetValidation() string {
 		case "int":
 			res += `
 	if ` + rawVarName + ` < ` + strconv.FormatInt(*vp.Min, 10) + ` {
-		w.WriteHeader(http.StatusBadRequest)
-		rb, _ := json.Marshal(&ResponseEnvelope{"` + vp.ParamName + ` must be >= ` + strconv.FormatInt(*vp.Min, 10) + `",nil})
-		_, _ = w.Write(rb)
-		return
+		` + vp.validationError("min", strconv.FormatInt(*vp.Min, 10)) + `
 	}
 `
 		case "string":
 			res += `
 	if len(` + rawVarName + `) < ` + strconv.FormatInt(*vp.Min, 10) + ` {
-		w.WriteHeader(http.StatusBadRequest)
-		rb, _ := json.Marshal(&ResponseEnvelope{"` + vp.ParamName + ` len must be >= ` + strconv.FormatInt(*vp.Min, 10) + `",nil})
-		_, _ = w.Write(rb)
-		return
+		` + vp.validationError("min_len", strconv.FormatInt(*vp.Min, 10)) + `
 	}
 `
 		}
@@ -178,10 +430,7 @@ func (vp *validateParams) GetValidation() string {
 		case "int":
 			res += `
 	if ` + rawVarName + ` > ` + strconv.FormatInt(vp.Max, 10) + ` {
-		w.WriteHeader(http.StatusBadRequest)
-		rb, _ := json.Marshal(&ResponseEnvelope{"` + vp.ParamName + ` must be <= ` + strconv.FormatInt(vp.Max, 10) + `",nil})
-		_, _ = w.Write(rb)
-		return
+		` + vp.validationError("max", strconv.FormatInt(vp.Max, 10)) + `
 	}
 `
 		}
@@ -190,113 +439,141 @@ func (vp *validateParams) GetValidation() string {
 	return res
 }
 
+// handlerTplParams is the data of handlerTpl.
 type handlerTplParams struct {
 	StructName     string
 	MethodName     string
+	HandlerName    string // name of the generated handler, e.g. handlerV1Create
+	Target         string // expression the method is called on, h or h.Service
+	Url            string // path the handler is mounted at, including the version prefix
 	Auth           bool
 	HttpMethod     string
-	ParamTypeName  string
+	ParamTypeName  string // empty if the method takes only ctx
+	ParamPointer   bool
+	HasResult      bool
 	ValidateParams []*validateParams
+	HasFiles       bool
+	MaxMemory      int64
+	MaxBody        int64
+	Etag           bool
+	MaxAge         int
+	Idempotent     bool
+	Metrics        bool
+	Deprecated     string // sunset date as in the annotation
+	Sunset         string // Deprecated formatted as an HTTP-date
+}
+
+type generatedFile struct {
+	Path    string
+	Content []byte
 }
 
+// apiMethod is an annotated method collected during parsing.
+type apiMethod struct {
+	Handler   *handlerTplParams
+	Signature *methodSignature
+}
+
+// httpTplParams is the data of httpTpl.
 type httpTplParams struct {
 	StructName    string
 	CodegenParams []*codegenParams
+	Cors          *corsParams
 }
 
-var (
-	handlerTpl = template.Must(template.New("handlerTpl").Parse(`
-func (h *{{.StructName}}) handler{{.MethodName}}(w http.ResponseWriter, r *http.Request) {
-	{{if ne .HttpMethod ""}}if r.Method != "{{.HttpMethod}}" {
-		w.WriteHeader(http.StatusNotAcceptable)
-		_, _ = w.Write([]byte("{\"error\":\"bad method\"}"))
-		return
-	}{{end}}{{if .Auth}}
-	if strings.Compare(r.Header.Get("X-Auth"), "100500") != 0 {
-		w.WriteHeader(http.StatusForbidden)
-		_, _ = w.Write([]byte("{\"error\":\"unauthorized\"}"))
-		return
-	}
-	{{end}}params := {{.ParamTypeName}}{}
-	{{range $f := .ValidateParams}}
-	{{$f.GetValueFromRequest $.HttpMethod}}
-	{{$f.GetValidation}}
-	params.{{$f.FieldName}} = raw{{$f.FieldName}}
-	{{end}}
-	ctx := context.Background()
-	res, err := h.{{.MethodName}}(ctx, params)
-	if err != nil {
-		c := http.StatusInternalServerError
-		e := err.Error()
-		if err, ok := err.(ApiError); ok {
-			c = err.HTTPStatus
-		}
-		w.WriteHeader(c)
-		rb, _ := json.Marshal(map[string]string{"error": e})
-		_, _ = w.Write(rb)
-		return
+// i18nTplParams is the data of i18nTpl.
+type i18nTplParams struct {
+	Messages      map[string]map[string]string
+	DefaultLocale string
+}
+
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
 	}
-	rb, _ := json.Marshal(&ResponseEnvelope{"", res})
-	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write(rb)
+	return strings.ToLower(s[:1]) + s[1:]
 }
-`))
-
-	httpTpl = template.Must(template.New("httpTpl").Parse(`
-func (h *{{.StructName}}) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	switch r.URL.Path {
-	{{range $cp := .CodegenParams}}case "{{$cp.Url}}":
-		h.{{$cp.FuncName}}(w, r)
-	{{end}}default:
-		w.WriteHeader(http.StatusNotFound)
-		rb, _ := json.Marshal(map[string]string{"error": "unknown method"})
-		_, _ = w.Write(rb)
+
+func upperFirst(s string) string {
+	if s == "" {
+		return s
 	}
+	return strings.ToUpper(s[:1]) + s[1:]
 }
-`))
 
-	resEnvelope = `
-type ResponseEnvelope struct {
-	Error string ` + "`json:\"error\"`" + `
-	Response interface{} ` + "`json:\"response,omitempty\"`" + `
+func parseCorsParams(g *ast.GenDecl, hub map[string]*corsParams) {
+	for _, spec := range g.Specs {
+		ts, ok := spec.(*ast.TypeSpec)
+		if !ok {
+			continue
+		}
+		doc := ts.Doc
+		if doc == nil {
+			doc = g.Doc
+		}
+		if doc == nil {
+			continue
+		}
+		for _, comment := range doc.List {
+			if !strings.HasPrefix(comment.Text, corsGenPrefix) {
+				continue
+			}
+			cp, err := newCorsParamsFromJSON([]byte(strings.TrimPrefix(comment.Text, corsGenPrefix)))
+			if err != nil {
+				log.Fatalf("FATAL incorrect apigen cors params for type %s: %s, params: %s", ts.Name.Name, err, comment.Text)
+			}
+			hub[ts.Name.Name] = cp
+		}
+	}
 }
-`
-	imports = `
-import (
-	"context"
-	"encoding/json"
-	"net/http"
-	"strconv"
-	"strings"
-)
-`
-)
 
 func main() {
+	flag.Parse()
+
+	var err error
+	if templates, err = loadTemplates(*tplDir); err != nil {
+		log.Fatalf("FATAL %s", err)
+	}
+	if *messagesIn != "" {
+		if err := loadValidationMessages(*messagesIn); err != nil {
+			log.Fatalf("FATAL messages: %s", err)
+		}
+	}
+
 	handlersHub := make(serveHTTPMethodsHub)
+	corsHub := make(map[string]*corsParams)
+	var methods []*apiMethod
+	importPaths := newImportSet("context", "encoding/json", "net/http", "strconv", "strings")
+	needETag := false
+	needIdempotency := false
+	needMultipart := false
+	needLog := false
 
 	fset := token.NewFileSet()
-	node, err := parser.ParseFile(fset, os.Args[1], nil, parser.ParseComments)
+	node, err := parser.ParseFile(fset, flag.Arg(0), nil, parser.ParseComments)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	out, _ := os.Create(os.Args[2])
+	structs := collectStructs(node)
 
-	if _, err := fmt.Fprintln(out, `package `+node.Name.Name); err != nil {
-		log.Fatal(err)
-	}
+	out := &bytes.Buffer{}
 
-	if _, err := fmt.Fprint(out, imports); err != nil {
+	if err := templates.ExecuteTemplate(out, "resEnvelope", nil); err != nil {
 		log.Fatal(err)
 	}
 
-	if _, err := fmt.Fprint(out, resEnvelope); err != nil {
+	if err := templates.ExecuteTemplate(out, "i18nTpl", i18nTplParams{validationMessages, defaultLocale}); err != nil {
 		log.Fatal(err)
 	}
 
 	// Parse
 	for _, f := range node.Decls {
+		if g, ok := f.(*ast.GenDecl); ok {
+			parseCorsParams(g, corsHub)
+			continue
+		}
+
 		fn, ok := f.(*ast.FuncDecl)
 		if !ok {
 			continue
@@ -305,90 +582,221 @@ func main() {
 		if fn.Doc == nil {
 			continue
 		}
-		needCodegen := false
-		var cp *codegenParams
+		var cps []*codegenParams
 		for _, comment := range fn.Doc.List {
 			if strings.HasPrefix(comment.Text, apiGenPrefix) {
-				needCodegen = true
-				if cp, err = newCodegenParamsFromJSON([]byte(strings.TrimPrefix(comment.Text, apiGenPrefix))); err != nil {
+				cp, err := newCodegenParamsFromJSON([]byte(strings.TrimPrefix(comment.Text, apiGenPrefix)))
+				if err != nil {
 					log.Fatalf("FATAL incorrect apigen params for func %s, params: %s", fn.Name.Name, comment.Text)
 				}
+				cps = append(cps, cp)
 			}
 		}
-		if !needCodegen {
+		if len(cps) == 0 {
 			continue
 		}
-		cp.FuncName = "handler" + fn.Name.Name
-		structName := fn.Recv.List[0].Type.(*ast.StarExpr).X.(*ast.Ident).Name
-		handlersHub.AddHandlerForStruct(structName, cp)
-
-		// Parse second argument
-		at := fn.Type.Params.List[1].Type.(*ast.Ident).Obj.Decl.(*ast.TypeSpec)
-		argStructName := at.Name.Name
-		argStructFields := at.Type.(*ast.StructType).Fields.List
-
-		vp := make([]*validateParams, len(argStructFields), len(argStructFields))
-
-		for i, field := range argStructFields {
-			fieldName := field.Names[0].Name
+		ms, err := parseMethodSignature(fn, structs)
+		if err != nil {
+			log.Fatalf("FATAL %s: %s", fset.Position(fn.Pos()), err)
+		}
 
-			if field.Tag == nil {
-				continue
+		var vp []*validateParams
+		if ms.ParamStruct != nil {
+			if vp, err = parseValidateParams(ms.ParamStruct); err != nil {
+				log.Fatalf("FATAL %s: params %s: %s", fset.Position(ms.ParamStruct.Pos()), ms.ParamTypeName, err)
 			}
+		}
 
-			tag := reflect.StructTag(field.Tag.Value[1 : len(field.Tag.Value)-1])
-			if len(tag.Get("apivalidator")) < 1 {
-				continue
+		hasFiles := false
+		for _, v := range vp {
+			hasFiles = hasFiles || v.IsFile()
+		}
+		needMultipart = needMultipart || hasFiles
+
+		for _, cp := range cps {
+			cp.FuncName = "handler" + upperFirst(cp.Version) + fn.Name.Name
+			if err := handlersHub.AddHandlerForStruct(ms.StructName, cp); err != nil {
+				log.Fatalf("FATAL %s: method %s: %s", fset.Position(fn.Pos()), fn.Name.Name, err)
 			}
 
-			fieldType := field.Type.(*ast.Ident).Name
+			needETag = needETag || cp.Etag
+			needIdempotency = needIdempotency || cp.Idempotent
 
-			if fieldType != "int" && fieldType != "string" {
-				continue
+			maxMemory := int64(defaultMaxMemory)
+			if cp.MaxMemory != "" {
+				if maxMemory, err = parseSize(cp.MaxMemory); err != nil {
+					log.Fatalf("FATAL %s: method %s: bad max_memory: %s", fset.Position(fn.Pos()), fn.Name.Name, err)
+				}
 			}
 
-			v := &validateParams{
-				FieldName: fieldName,
-				FieldType: fieldType,
-				ParamName: strings.ToLower(fieldName),
+			sunset := ""
+			if cp.Deprecated != "" {
+				needLog = true
+				d, err := time.Parse("2006-01-02", cp.Deprecated)
+				if err != nil {
+					log.Fatalf("FATAL %s: method %s: deprecated must be a YYYY-MM-DD sunset date, got %q", fset.Position(fn.Pos()), fn.Name.Name, cp.Deprecated)
+				}
+				sunset = d.UTC().Format(http.TimeFormat)
 			}
 
-			tagArgs := strings.Split(tag.Get("apivalidator"), ",")
-
-			for _, tagArg := range tagArgs {
-				tagTokens := strings.Split(tagArg, "=")
-				switch tagTokens[0] {
-				case "required":
-					v.Required = true
-				case "paramname":
-					v.ParamName = tagTokens[1]
-				case "enum":
-					v.Enum = strings.Split(tagTokens[1], "|")
-					//v.Required = true
-				case "default":
-					v.Default = tagTokens[1]
-				case "min":
-					num, _ := strconv.ParseInt(tagTokens[1], 10, 64)
-					v.Min = &num
-				case "max":
-					num, _ := strconv.ParseInt(tagTokens[1], 10, 64)
-					v.Max = num
-				}
+			hp := handlerTplParams{
+				StructName:     ms.StructName,
+				MethodName:     fn.Name.Name,
+				HandlerName:    cp.FuncName,
+				Target:         "h",
+				Url:            cp.Path(),
+				Auth:           cp.Auth,
+				HttpMethod:     cp.Method,
+				ParamTypeName:  ms.ParamTypeName,
+				ParamPointer:   ms.ParamPointer,
+				HasResult:      ms.HasResult,
+				ValidateParams: vp,
+				HasFiles:       hasFiles,
+				MaxMemory:      maxMemory,
+				MaxBody:        maxBodySize(vp),
+				Etag:           cp.Etag,
+				MaxAge:         cp.MaxAge,
+				Idempotent:     cp.Idempotent,
+				Metrics:        *withMetrics,
+				Deprecated:     cp.Deprecated,
+				Sunset:         sunset,
 			}
+			if err := templates.ExecuteTemplate(out, "handlerTpl", hp); err != nil {
+				log.Fatal(err)
+			}
+			methods = append(methods, &apiMethod{&hp, ms})
+		}
+	}
+
+	if needETag {
+		importPaths.Add("crypto/sha1", "encoding/hex")
+		if err := templates.ExecuteTemplate(out, "etagHelpers", nil); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *withMetrics && len(handlersHub) > 0 {
+		importPaths.Add("bufio", "fmt", "net", "sort", "sync", "time")
+		if err := templates.ExecuteTemplate(out, "metricsHelpers", nil); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if needLog {
+		importPaths.Add("log")
+	}
 
-			vp[i] = v
+	if needMultipart {
+		importPaths.Add("errors", "io", "io/ioutil", "mime/multipart")
+		if err := templates.ExecuteTemplate(out, "multipartHelpers", nil); err != nil {
+			log.Fatal(err)
 		}
+	}
 
-		if err := handlerTpl.Execute(out, handlerTplParams{structName, fn.Name.Name, cp.Auth, cp.Method, argStructName, vp}); err != nil {
+	if needIdempotency {
+		importPaths.Add("container/list", "crypto/sha256", "encoding/hex", "sync")
+		if err := templates.ExecuteTemplate(out, "idempotencyHelpers", nil); err != nil {
 			log.Fatal(err)
 		}
 	}
 
 	// Generate ServeHTTP method for structs
-	for sn, cp := range handlersHub {
-		if err := httpTpl.Execute(out, httpTplParams{sn, cp}); err != nil {
+	for _, sn := range handlersHub.StructNames() {
+		if err := templates.ExecuteTemplate(out, "httpTpl", httpTplParams{sn, handlersHub[sn], corsHub[sn]}); err != nil {
 			log.Fatal(err)
 		}
 	}
 
+	if len(corsHub) > 0 {
+		if err := templates.ExecuteTemplate(out, "corsHelpers", nil); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	src := &bytes.Buffer{}
+
+	if _, err := fmt.Fprintf(src, "// Code generated by codegen from %s; DO NOT EDIT.\n\n", filepath.Base(flag.Arg(0))); err != nil {
+		log.Fatal(err)
+	}
+
+	if _, err := fmt.Fprintln(src, `package `+node.Name.Name); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := templates.ExecuteTemplate(src, "imports", importPaths.Sorted()); err != nil {
+		log.Fatal(err)
+	}
+
+	if _, err := out.WriteTo(src); err != nil {
+		log.Fatal(err)
+	}
+
+	formatted, err := format.Source(src.Bytes())
+	if err != nil {
+		log.Fatalf("FATAL generated code is not valid Go: %s", err)
+	}
+
+	outputs := []generatedFile{{flag.Arg(1), formatted}}
+
+	if *tsOut != "" {
+		ts, err := generateTypeScript(flag.Arg(0), methods, structs)
+		if err != nil {
+			log.Fatalf("FATAL typescript: %s", err)
+		}
+		outputs = append(outputs, generatedFile{*tsOut, ts})
+	}
+
+	if *cliOut != "" {
+		cli, err := generateCLI(flag.Arg(0), *cliApi, methods)
+		if err != nil {
+			log.Fatalf("FATAL cli: %s", err)
+		}
+		outputs = append(outputs, generatedFile{*cliOut, cli})
+	}
+
+	if *mocksOut != "" {
+		mocks, err := generateMocks(flag.Arg(0), node, methods, handlersHub, corsHub)
+		if err != nil {
+			log.Fatalf("FATAL mocks: %s", err)
+		}
+		outputs = append(outputs, generatedFile{*mocksOut, mocks})
+	}
+
+	if *testsOut != "" {
+		if *mocksOut == "" {
+			log.Fatal("FATAL tests: -tests requires -mocks, the tests are run over the generated mocks")
+		}
+		tests, err := generateValidationTests(flag.Arg(0), node.Name.Name, methods)
+		if err != nil {
+			log.Fatalf("FATAL tests: %s", err)
+		}
+		outputs = append(outputs, generatedFile{*testsOut, tests})
+	}
+
+	if *checkOnly {
+		drift := false
+		for _, gf := range outputs {
+			current, err := ioutil.ReadFile(gf.Path)
+			if err != nil && !os.IsNotExist(err) {
+				log.Fatal(err)
+			}
+			if !bytes.Equal(current, gf.Content) {
+				fmt.Fprintf(os.Stderr, "%s is out of date, regenerate it from %s\n", gf.Path, flag.Arg(0))
+				drift = true
+			}
+		}
+		if drift {
+			os.Exit(1)
+		}
+		return
+	}
+
+	for _, gf := range outputs {
+		if err := os.MkdirAll(filepath.Dir(gf.Path), 0755); err != nil {
+			log.Fatal(err)
+		}
+		if err := ioutil.WriteFile(gf.Path, gf.Content, 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
 }
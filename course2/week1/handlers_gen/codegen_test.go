@@ -0,0 +1,356 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files in ../testdata")
+
+// codegenBin is the generator built by TestMain, empty with -short
+var codegenBin string
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if testing.Short() {
+		os.Exit(m.Run())
+	}
+	dir, err := ioutil.TempDir("", "codegen")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if codegenBin, err = buildCodegen(dir); err != nil {
+		os.RemoveAll(dir)
+		log.Fatal(err)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// buildCodegen copies the generator sources with extra files to dir and builds
+// dir/codegen from them, as go build handlers_gen/* does
+func buildCodegen(dir string, extra ...string) (string, error) {
+	sources, _ := filepath.Glob("*.go")
+	args := []string{"build", "-o", "codegen"}
+	for _, s := range append(sources, extra...) {
+		if strings.HasSuffix(s, "_test.go") {
+			continue
+		}
+		b, err := ioutil.ReadFile(s)
+		if err != nil {
+			return "", err
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, filepath.Base(s)), b, 0644); err != nil {
+			return "", err
+		}
+		args = append(args, filepath.Base(s))
+	}
+	cmd := exec.Command("go", args...)
+	cmd.Dir = dir
+	if b, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("go build: %v\n%s", err, b)
+	}
+	return filepath.Join(dir, "codegen"), nil
+}
+
+// generate copies files to a temporary directory and generates api_handlers.go there
+// from api.go with the generator args
+func generate(t *testing.T, files []string, args ...string) string {
+	if codegenBin == "" {
+		t.Skip("skipping generator run in short mode")
+	}
+	dir, err := ioutil.TempDir("", "apigen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, filepath.Base(f)), b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	run(t, dir, codegenBin, append(args, "api.go", "api_handlers.go")...)
+	return dir
+}
+
+// run runs the command in dir and fails the test with its output if it fails
+func run(t *testing.T, dir, name string, args ...string) string {
+	cmd := exec.Command(name, args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%s %s: %v\n%s", name, strings.Join(args, " "), err, out)
+	}
+	return string(out)
+}
+
+// goFiles returns the .go files of dir for go commands, which then need no go.mod
+func goFiles(t *testing.T, dir string) []string {
+	files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, f := range files {
+		files[i] = filepath.Base(f)
+	}
+	return files
+}
+
+func TestCheck(t *testing.T) {
+	dir := generate(t, []string{"../api.go"})
+	out := filepath.Join(dir, "api_handlers.go")
+	check := func() int {
+		cmd := exec.Command(codegenBin, "-check", "api.go", "api_handlers.go")
+		cmd.Dir = dir
+		err := cmd.Run()
+		if ee, ok := err.(*exec.ExitError); ok {
+			return ee.ExitCode()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return 0
+	}
+
+	if code := check(); code != 0 {
+		t.Errorf("up to date output: expected exit code 0, got %d", code)
+	}
+	generated, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(out, append(generated, "\n// edited\n"...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if code := check(); code != 1 {
+		t.Errorf("edited output: expected exit code 1, got %d", code)
+	}
+	if err := os.Remove(out); err != nil {
+		t.Fatal(err)
+	}
+	if code := check(); code != 1 {
+		t.Errorf("missing output: expected exit code 1, got %d", code)
+	}
+}
+
+func TestStableOutput(t *testing.T) {
+	// api.go has several API structs, their ServeHTTP methods must not follow map order
+	var first []byte
+	for i := 0; i < 5; i++ {
+		dir := generate(t, []string{"../api.go"})
+		got, err := ioutil.ReadFile(filepath.Join(dir, "api_handlers.go"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			first = got
+		} else if string(got) != string(first) {
+			t.Fatalf("run %d generated different output", i+1)
+		}
+	}
+}
+
+func TestCorsParams(t *testing.T) {
+	cases := []struct {
+		json string
+		ok   bool
+	}{
+		{`{"origins": ["https://app.example.com"], "credentials": true}`, true},
+		{`{"origins": ["*"]}`, true},
+		{`{"origins": ["*"], "credentials": true}`, false},
+		{`{"origins": ["https://app.example.com", "*"], "credentials": true}`, false},
+		{`{"origins": "*"}`, false},
+	}
+	for _, c := range cases {
+		_, err := newCorsParamsFromJSON([]byte(c.json))
+		if (err == nil) != c.ok {
+			t.Errorf("%s: expected ok %v, got error %v", c.json, c.ok, err)
+		}
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	dir := generate(t, []string{"../api.go", "../main.go", "../main_test.go", "../testdata/metrics_test.go"}, "-metrics")
+	run(t, dir, "go", append([]string{"test", "-run", "Metrics"}, goFiles(t, dir)...)...)
+}
+
+func TestCLI(t *testing.T) {
+	dir := generate(t, []string{"../api.go", "../main.go", "../testdata/cli_test.go"}, "-cli", "cli/main.go")
+	run(t, dir, "go", "build", "-o", "apicli", "cli/main.go")
+	t.Setenv("CLI_BIN", filepath.Join(dir, "apicli"))
+	run(t, dir, "go", append([]string{"test", "-run", "CLI"}, goFiles(t, dir)...)...)
+}
+
+func TestTemplates(t *testing.T) {
+	tplDir, err := filepath.Abs("../testdata/templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := generate(t, []string{"../api.go", "../main.go", "../testdata/envelope_test.go"}, "-templates", tplDir)
+	run(t, dir, "go", append([]string{"test", "-run", "EnvelopeTemplate"}, goFiles(t, dir)...)...)
+}
+
+func TestMocksAndValidationTests(t *testing.T) {
+	// ItemApi adds what api.go lacks, an optional int with a default
+	src := filepath.Join(t.TempDir(), "api.go")
+	err := ioutil.WriteFile(src, []byte(`package main
+
+import "context"
+
+type ApiError struct {
+	HTTPStatus int
+	Err        error
+}
+
+func (ae ApiError) Error() string {
+	return ae.Err.Error()
+}
+
+type ItemApi struct{}
+
+type ItemParams struct {
+	Name  string `+"`apivalidator:\"required\"`"+`
+	Count int    `+"`apivalidator:\"default=5,max=10\"`"+`
+}
+
+// apigen:api {"url": "/item/get"}
+func (srv *ItemApi) Get(ctx context.Context, in ItemParams) (*ItemParams, error) {
+	return &in, nil
+}
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		files []string
+		apis  []string
+	}{
+		{[]string{"../api.go", "../main.go"}, []string{"MyApi", "OtherApi", "StatusApi"}},
+		{[]string{src}, []string{"ItemApi"}},
+	} {
+		dir := generate(t, c.files, "-mocks", "api_mocks_test.go", "-tests", "api_validation_test.go")
+		files := goFiles(t, dir)
+		run(t, dir, "go", append([]string{"vet"}, files...)...)
+		out := run(t, dir, "go", append([]string{"test", "-v", "-run", "Validation"}, files...)...)
+		for _, api := range c.apis {
+			if !strings.Contains(out, "--- PASS: Test"+api+"Validation") {
+				t.Errorf("Test%sValidation did not pass\n%s", api, out)
+			}
+		}
+	}
+}
+
+func TestValidParams(t *testing.T) {
+	min := int64(5)
+	vp := &validateParams{FieldName: "Class", FieldType: "string", ParamName: "class", Enum: []string{"mage", "rouge", "warrior"}, Min: &min}
+	values, err := validParams([]*validateParams{vp})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := values.Get("class"); got != "rouge" {
+		t.Errorf("expected the first enum value passing min, rouge, got %q", got)
+	}
+	min = 10
+	if _, err := validParams([]*validateParams{vp}); err == nil {
+		t.Errorf("expected error if no enum value passes min")
+	}
+}
+
+func TestRegisterTemplateFunc(t *testing.T) {
+	if codegenBin == "" {
+		t.Skip("skipping generator build in short mode")
+	}
+	// the generator with funcs.go next to its sources, as users add their funcs
+	bin, err := buildCodegen(t.TempDir(), "../testdata/templatefuncs/funcs.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tplDir, err := filepath.Abs("../testdata/templatefuncs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := generate(t, []string{"../api.go", "../main.go"})
+	run(t, dir, bin, "-templates", tplDir, "api.go", "api_handlers.go")
+	run(t, dir, "go", append([]string{"vet"}, goFiles(t, dir)...)...)
+	got, err := ioutil.ReadFile(filepath.Join(dir, "api_handlers.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(got), "Response interface{} `json:\"response,omitempty\"`") {
+		t.Errorf("api_handlers.go does not contain the field rendered with jsonTag\n%s", got)
+	}
+}
+
+func TestTypeScript(t *testing.T) {
+	dir := generate(t, []string{"../api.go"}, "-ts", "api.ts")
+	got, err := ioutil.ReadFile(filepath.Join(dir, "api.ts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *update {
+		if err := ioutil.WriteFile("../testdata/api.ts", got, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected, err := ioutil.ReadFile("../testdata/api.ts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(expected) {
+		t.Errorf("api.ts differs from ../testdata/api.ts, run go test with -update if it is expected\n%s", got)
+	}
+}
+
+func TestTypeScriptStructs(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "api.go")
+	err := ioutil.WriteFile(src, []byte(`package main
+
+import (
+	"context"
+	"sync"
+)
+
+type ItemApi struct{}
+
+type Item struct {
+	Name string `+"`apivalidator:\"required\"`"+`
+	Tags []string
+	mu   sync.Mutex
+}
+
+// apigen:api {"url": "/item/put", "method": "POST"}
+func (srv *ItemApi) Put(ctx context.Context, in Item) (*Item, error) {
+	return &in, nil
+}
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := generate(t, []string{src}, "-ts", "api.ts")
+	got, err := ioutil.ReadFile(filepath.Join(dir, "api.ts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range []string{
+		"export interface Item {\n  name: string;\n}\n",
+		"export interface ItemResult {\n  Name: string;\n  Tags: string[];\n}\n",
+		"export function itemApiPut(opts: ApiClientOptions, params: Item): Promise<ItemResult>",
+	} {
+		if !strings.Contains(string(got), e) {
+			t.Errorf("api.ts does not contain %q\n%s", e, got)
+		}
+	}
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type cliParam struct {
+	Name    string
+	Usage   string
+	File    bool
+	Default string
+}
+
+type cliCommand struct {
+	Path       []string
+	Url        string
+	HttpMethod string
+	Auth       bool
+	Multipart  bool
+	Params     []*cliParam
+}
+
+// cliTplParams is the data of cliTpl.
+type cliTplParams struct {
+	Source   string
+	Apis     []string
+	Commands []*cliCommand
+}
+
+const cliTpl = `// Code generated by codegen from {{.Source}}; DO NOT EDIT.
+
+// Command line client for {{join .Apis ", "}}.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type cliParam struct {
+	Name    string
+	Usage   string
+	File    bool
+	Default string
+}
+
+type cliCommand struct {
+	Path       []string
+	Url        string
+	HttpMethod string
+	Auth       bool
+	Multipart  bool
+	Params     []cliParam
+}
+
+var commands = []cliCommand{
+	{{range .Commands}}{
+		Path:       []string{ {{range $i, $p := .Path}}{{if $i}}, {{end}}{{printf "%q" $p}}{{end}} },
+		Url:        {{printf "%q" .Url}},
+		HttpMethod: {{printf "%q" .HttpMethod}},
+		Auth:       {{.Auth}},
+		Multipart:  {{.Multipart}},
+		Params: []cliParam{
+			{{range .Params}}{ {{printf "%q" .Name}}, {{printf "%q" .Usage}}, {{.File}}, {{printf "%q" .Default}} },
+			{{end}}},
+	},
+	{{end}}}
+
+func main() {
+	args := os.Args[1:]
+	for _, c := range commands {
+		if len(args) >= len(c.Path) && strings.Join(args[:len(c.Path)], " ") == strings.Join(c.Path, " ") {
+			os.Exit(run(c, args[len(c.Path):]))
+		}
+	}
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	name := filepath.Base(os.Args[0])
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags]\n\nCommands:\n", name)
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %s %s\n", name, strings.Join(c.Path, " "))
+	}
+	fmt.Fprintf(os.Stderr, "\nRun '%s <command> -h' to see command flags.\n", name)
+}
+
+func run(c cliCommand, args []string) int {
+	fs := flag.NewFlagSet(strings.Join(c.Path, " "), flag.ContinueOnError)
+	baseURL := fs.String("url", "http://localhost:8080", "API base URL")
+	auth := fs.String("auth", "", "value of the X-Auth header")
+	values := make(map[string]*string, len(c.Params))
+	for _, p := range c.Params {
+		values[p.Name] = fs.String(p.Name, p.Default, p.Usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	// params with defaults are always sent, the API requires ints even if they are optional
+	set := make(map[string]bool)
+	for _, p := range c.Params {
+		set[p.Name] = p.Default != ""
+	}
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	req, err := newRequest(c, *baseURL, values, set)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *auth != "" {
+		req.Header.Set("X-Auth", *auth)
+	} else if c.Auth {
+		fmt.Fprintln(os.Stderr, "warning: command requires authorization, use --auth")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	out := &bytes.Buffer{}
+	if err := json.Indent(out, body, "", "  "); err != nil {
+		out.Reset()
+		out.Write(body)
+	}
+	out.WriteByte('\n')
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		fmt.Fprintf(os.Stderr, "%s\n", resp.Status)
+		out.WriteTo(os.Stderr)
+		return 1
+	}
+	out.WriteTo(os.Stdout)
+	return 0
+}
+
+func newRequest(c cliCommand, baseURL string, values map[string]*string, set map[string]bool) (*http.Request, error) {
+	target := strings.TrimSuffix(baseURL, "/") + c.Url
+
+	if c.Multipart {
+		body := &bytes.Buffer{}
+		mw := multipart.NewWriter(body)
+		for _, p := range c.Params {
+			if !set[p.Name] {
+				continue
+			}
+			if !p.File {
+				if err := mw.WriteField(p.Name, *values[p.Name]); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			if err := writeFile(mw, p.Name, *values[p.Name]); err != nil {
+				return nil, err
+			}
+		}
+		if err := mw.Close(); err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequest(c.HttpMethod, target, body)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		return req, nil
+	}
+
+	form := url.Values{}
+	for _, p := range c.Params {
+		if set[p.Name] {
+			form.Set(p.Name, *values[p.Name])
+		}
+	}
+	if c.HttpMethod == http.MethodGet {
+		if len(form) > 0 {
+			target += "?" + form.Encode()
+		}
+		return http.NewRequest(c.HttpMethod, target, nil)
+	}
+	req, err := http.NewRequest(c.HttpMethod, target, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req, nil
+}
+
+func writeFile(mw *multipart.Writer, name, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fw, err := mw.CreateFormFile(name, filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, f)
+	return err
+}
+`
+
+// Help describes the validation rules of a param for command line usage.
+func (vp *validateParams) Help() string {
+	var res []string
+	if vp.IsFile() {
+		res = append(res, "path to file")
+	}
+	if vp.Required {
+		res = append(res, "required")
+	}
+	if len(vp.Enum) > 0 {
+		res = append(res, "one of "+strings.Join(vp.Enum, "|"))
+	}
+	if vp.Default != "" {
+		res = append(res, "default "+vp.Default)
+	}
+	if vp.Min != nil {
+		if vp.FieldType == "string" {
+			res = append(res, "min length "+strconv.FormatInt(*vp.Min, 10))
+		} else {
+			res = append(res, "min "+strconv.FormatInt(*vp.Min, 10))
+		}
+	}
+	if vp.Max > 0 {
+		res = append(res, "max "+strconv.FormatInt(vp.Max, 10))
+	}
+	if vp.MaxSize > 0 {
+		res = append(res, "max size "+vp.MaxSizeRaw)
+	}
+	if len(vp.Mime) > 0 {
+		res = append(res, "types "+strings.Join(vp.Mime, "|"))
+	}
+	if vp.FieldType == "int" {
+		res = append(res, "int")
+	}
+	if len(res) == 0 {
+		res = append(res, "optional")
+	}
+	return strings.Join(res, ", ")
+}
+
+// generateCLI generates a main package with a subcommand per annotated method of the api struct.
+// If api is empty, it generates commands for all structs, prefixed with the lowercased struct name
+// when there are several of them.
+func generateCLI(source, api string, methods []*apiMethod) ([]byte, error) {
+	params := cliTplParams{Source: filepath.Base(source)}
+	seen := make(map[string]bool)
+	for _, m := range methods {
+		sn := m.Handler.StructName
+		if (api == "" || sn == api) && !seen[sn] {
+			seen[sn] = true
+			params.Apis = append(params.Apis, sn)
+		}
+	}
+
+	for _, m := range methods {
+		h := m.Handler
+		if api != "" && h.StructName != api {
+			continue
+		}
+
+		path := strings.Split(strings.Trim(h.Url, "/"), "/")
+		if len(params.Apis) > 1 {
+			path = append([]string{strings.ToLower(h.StructName)}, path...)
+		}
+		c := &cliCommand{
+			Path:       path,
+			Url:        h.Url,
+			HttpMethod: h.HttpMethod,
+			Auth:       h.Auth,
+			Multipart:  h.HasFiles,
+		}
+		if c.HttpMethod == "" {
+			c.HttpMethod = "GET"
+			if h.HasFiles {
+				c.HttpMethod = "POST"
+			}
+		}
+		for _, vp := range h.ValidateParams {
+			def := ""
+			if vp.FieldType == "int" {
+				def = vp.Default
+				if def == "" {
+					def = "0"
+				}
+			}
+			c.Params = append(c.Params, &cliParam{vp.ParamName, vp.Help(), vp.IsFile(), def})
+		}
+		params.Commands = append(params.Commands, c)
+	}
+
+	if len(params.Commands) == 0 {
+		return nil, fmt.Errorf("no annotated methods found for %q", api)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := templates.ExecuteTemplate(buf, "cliTpl", params); err != nil {
+		return nil, err
+	}
+	return format.Source(buf.Bytes())
+}
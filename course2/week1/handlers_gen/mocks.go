@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type mockMethod struct {
+	Name       string
+	ParamType  string // empty if the method takes only ctx
+	ResultType string // empty if the method returns only error
+}
+
+type mockApi struct {
+	StructName string
+	Methods    []*mockMethod
+	Handlers   string // generated handlers and ServeHTTP of the service handler
+}
+
+// mocksTplParams is the data of mocksTpl.
+type mocksTplParams struct {
+	Source  string
+	Package string
+	Imports []string // candidates, unused ones are removed after rendering
+	Apis    []*mockApi
+}
+
+const mocksTpl = `// Code generated by codegen from {{.Source}}; DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+{{range .Imports}}	"{{.}}"
+{{end}})
+{{range .Apis}}{{$api := .StructName}}
+// {{$api}}Service covers the annotated methods of {{$api}}.
+type {{$api}}Service interface {
+{{range .Methods}}	{{.Name}}(ctx context.Context{{if .ParamType}}, in {{.ParamType}}{{end}}) ({{if .ResultType}}{{.ResultType}}, {{end}}error)
+{{end}}}
+
+var _ {{$api}}Service = (*{{$api}})(nil)
+
+// {{$api}}ServiceMock is a configurable {{$api}}Service. A method without a func
+// set returns zero values. Every call is recorded with the params bound from the request.
+type {{$api}}ServiceMock struct {
+	mu sync.Mutex
+{{range .Methods}}
+	{{.Name}}Func  func(ctx context.Context{{if .ParamType}}, in {{.ParamType}}{{end}}) ({{if .ResultType}}{{.ResultType}}, {{end}}error)
+	{{.Name}}Calls []{{if .ParamType}}{{.ParamType}}{{else}}struct{}{{end}}
+{{end}}}
+{{range .Methods}}
+func (m *{{$api}}ServiceMock) {{.Name}}(ctx context.Context{{if .ParamType}}, in {{.ParamType}}{{end}}) ({{if .ResultType}}{{.ResultType}}, {{end}}error) {
+	m.mu.Lock()
+	m.{{.Name}}Calls = append(m.{{.Name}}Calls, {{if .ParamType}}in{{else}}struct{}{}{{end}})
+	f := m.{{.Name}}Func
+	m.mu.Unlock()
+	if f != nil {
+		return f(ctx{{if .ParamType}}, in{{end}})
+	}
+	{{if .ResultType}}var res {{.ResultType}}
+	return res, nil{{else}}return nil{{end}}
+}
+{{end}}
+// {{$api}}ServiceHandler serves the generated handlers of {{$api}} over any {{$api}}Service.
+type {{$api}}ServiceHandler struct {
+	Service {{$api}}Service
+}
+{{.Handlers}}
+// New{{$api}}TestServer starts an httptest.Server with the generated handlers of {{$api}} over svc.
+// The caller must Close it.
+func New{{$api}}TestServer(svc {{$api}}Service) *httptest.Server {
+	return httptest.NewServer(&{{$api}}ServiceHandler{svc})
+}
+{{end}}`
+
+// generateMocks generates a service interface, a mock and an httptest server per api struct.
+// Handlers are rendered with the same templates as the real ones, so the mock is
+// served with the same routing, binding and validation.
+func generateMocks(source string, node *ast.File, methods []*apiMethod, hub serveHTTPMethodsHub, cors map[string]*corsParams) ([]byte, error) {
+	params := mocksTplParams{
+		Source:  filepath.Base(source),
+		Package: node.Name.Name,
+	}
+	imports := newImportSet("bytes", "context", "encoding/json", "errors", "fmt", "io", "log", "mime/multipart",
+		"net/http", "net/http/httptest", "strconv", "strings", "sync", "time")
+
+	for _, sn := range hub.StructNames() {
+		api := &mockApi{StructName: sn}
+		seen := make(map[string]bool)
+		handlers := &bytes.Buffer{}
+
+		for _, m := range methods {
+			h := m.Handler
+			if h.StructName != sn {
+				continue
+			}
+
+			sh := *h
+			sh.StructName = sn + "ServiceHandler"
+			sh.Target = "h.Service"
+			if err := templates.ExecuteTemplate(handlers, "handlerTpl", sh); err != nil {
+				return nil, err
+			}
+
+			if seen[h.MethodName] {
+				continue
+			}
+			seen[h.MethodName] = true
+
+			mm := &mockMethod{Name: h.MethodName, ParamType: m.Signature.ParamTypeName}
+			if m.Signature.ParamPointer {
+				mm.ParamType = "*" + mm.ParamType
+			}
+			if m.Signature.HasResult {
+				mm.ResultType = types.ExprString(m.Signature.ResultType)
+				addTypeImports(imports, node, m.Signature.ResultType)
+			}
+			api.Methods = append(api.Methods, mm)
+		}
+
+		if err := templates.ExecuteTemplate(handlers, "httpTpl", httpTplParams{sn + "ServiceHandler", hub[sn], cors[sn]}); err != nil {
+			return nil, err
+		}
+		api.Handlers = handlers.String()
+		params.Apis = append(params.Apis, api)
+	}
+	params.Imports = imports.Sorted()
+
+	buf := &bytes.Buffer{}
+	if err := templates.ExecuteTemplate(buf, "mocksTpl", params); err != nil {
+		return nil, err
+	}
+
+	// render again with only the imports the first pass referenced
+	used, err := usedPackages(buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	params.Imports = params.Imports[:0]
+	for _, path := range imports.Sorted() {
+		if used[path[strings.LastIndex(path, "/")+1:]] {
+			params.Imports = append(params.Imports, path)
+		}
+	}
+	buf.Reset()
+	if err := templates.ExecuteTemplate(buf, "mocksTpl", params); err != nil {
+		return nil, err
+	}
+	return format.Source(buf.Bytes())
+}
+
+// addTypeImports adds imports of the parsed file used by the type expression.
+func addTypeImports(imports importSet, node *ast.File, expr ast.Expr) {
+	ast.Inspect(expr, func(n ast.Node) bool {
+		se, ok := n.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		id, ok := se.X.(*ast.Ident)
+		if !ok {
+			return true
+		}
+		for _, is := range node.Imports {
+			path, _ := strconv.Unquote(is.Path.Value)
+			if importName(is, path) == id.Name {
+				imports.Add(path)
+			}
+		}
+		return false
+	})
+}
+
+func importName(is *ast.ImportSpec, path string) string {
+	if is.Name != nil {
+		return is.Name.Name
+	}
+	return path[strings.LastIndex(path, "/")+1:]
+}
+
+// usedPackages returns names used as selector operands in the source, which
+// includes every referenced package.
+func usedPackages(src []byte) (map[string]bool, error) {
+	f, err := parser.ParseFile(token.NewFileSet(), "", src, 0)
+	if err != nil {
+		return nil, err
+	}
+	used := make(map[string]bool)
+	ast.Inspect(f, func(n ast.Node) bool {
+		if se, ok := n.(*ast.SelectorExpr); ok {
+			if id, ok := se.X.(*ast.Ident); ok {
+				used[id.Name] = true
+			}
+		}
+		return true
+	})
+	return used, nil
+}
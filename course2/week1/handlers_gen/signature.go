@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// methodSignature describes the shape of an annotated method.
+// Supported shapes are
+//
+//	func (r T) M(ctx context.Context[, in P]) ([R, ]error)
+//
+// where T may be a pointer or a value receiver, P is a struct declared in the
+// parsed file passed by value or by pointer and R is any type.
+type methodSignature struct {
+	StructName    string
+	ParamTypeName string
+	ParamPointer  bool
+	ParamStruct   *ast.StructType
+	HasResult     bool
+	ResultType    ast.Expr
+}
+
+func collectStructs(node *ast.File) map[string]*ast.StructType {
+	res := make(map[string]*ast.StructType)
+	for _, d := range node.Decls {
+		g, ok := d.(*ast.GenDecl)
+		if !ok {
+			continue
+		}
+		for _, spec := range g.Specs {
+			ts, ok := spec.(*ast.TypeSpec)
+			if !ok {
+				continue
+			}
+			if st, ok := ts.Type.(*ast.StructType); ok {
+				res[ts.Name.Name] = st
+			}
+		}
+	}
+	return res
+}
+
+func parseMethodSignature(fn *ast.FuncDecl, structs map[string]*ast.StructType) (*methodSignature, error) {
+	ms := &methodSignature{}
+
+	if fn.Recv == nil || len(fn.Recv.List) != 1 {
+		return nil, fmt.Errorf("%s is not a method", fn.Name.Name)
+	}
+	recv := fn.Recv.List[0].Type
+	if se, ok := recv.(*ast.StarExpr); ok {
+		recv = se.X
+	}
+	ri, ok := recv.(*ast.Ident)
+	if !ok {
+		return nil, fmt.Errorf("method %s: unsupported receiver type %s", fn.Name.Name, types.ExprString(fn.Recv.List[0].Type))
+	}
+	ms.StructName = ri.Name
+
+	params := flattenFields(fn.Type.Params)
+	if len(params) < 1 || len(params) > 2 {
+		return nil, fmt.Errorf("method %s: expected (ctx context.Context) or (ctx context.Context, in Params) params, got %d", fn.Name.Name, len(params))
+	}
+	if types.ExprString(params[0]) != "context.Context" {
+		return nil, fmt.Errorf("method %s: first param must be context.Context, got %s", fn.Name.Name, types.ExprString(params[0]))
+	}
+	if len(params) == 2 {
+		pt := params[1]
+		if se, ok := pt.(*ast.StarExpr); ok {
+			ms.ParamPointer = true
+			pt = se.X
+		}
+		pi, ok := pt.(*ast.Ident)
+		if !ok {
+			return nil, fmt.Errorf("method %s: unsupported params type %s, must be a struct declared in the same file", fn.Name.Name, types.ExprString(params[1]))
+		}
+		st, ok := structs[pi.Name]
+		if !ok {
+			return nil, fmt.Errorf("method %s: params type %s must be a struct declared in the same file", fn.Name.Name, pi.Name)
+		}
+		ms.ParamTypeName = pi.Name
+		ms.ParamStruct = st
+	}
+
+	results := flattenFields(fn.Type.Results)
+	if len(results) < 1 || len(results) > 2 || types.ExprString(results[len(results)-1]) != "error" {
+		return nil, fmt.Errorf("method %s: expected (error) or (Result, error) results", fn.Name.Name)
+	}
+	ms.HasResult = len(results) == 2
+	if ms.HasResult {
+		ms.ResultType = results[0]
+	}
+
+	return ms, nil
+}
+
+// flattenFields returns a type per parameter, so "a, b int" becomes two entries.
+func flattenFields(fl *ast.FieldList) []ast.Expr {
+	if fl == nil {
+		return nil
+	}
+	res := make([]ast.Expr, 0, len(fl.List))
+	for _, f := range fl.List {
+		n := len(f.Names)
+		if n == 0 {
+			n = 1
+		}
+		for i := 0; i < n; i++ {
+			res = append(res, f.Type)
+		}
+	}
+	return res
+}
+
+func parseValidateParams(st *ast.StructType) ([]*validateParams, error) {
+	vp := make([]*validateParams, 0, len(st.Fields.List))
+
+	for _, field := range st.Fields.List {
+		if field.Tag == nil {
+			continue
+		}
+
+		tag := reflect.StructTag(field.Tag.Value[1 : len(field.Tag.Value)-1])
+		if len(tag.Get("apivalidator")) < 1 {
+			continue
+		}
+
+		if len(field.Names) != 1 {
+			return nil, fmt.Errorf("field %s: apivalidator tag is supported only for named fields declared one per line", types.ExprString(field.Type))
+		}
+		fieldName := field.Names[0].Name
+
+		fieldType := types.ExprString(field.Type)
+		switch fieldType {
+		case "int", "string", "*multipart.FileHeader", "[]byte":
+		default:
+			return nil, fmt.Errorf("field %s: unsupported type %s, only int, string, *multipart.FileHeader and []byte are supported", fieldName, fieldType)
+		}
+
+		v := &validateParams{
+			FieldName: fieldName,
+			FieldType: fieldType,
+			ParamName: strings.ToLower(fieldName),
+		}
+
+		tagArgs := strings.Split(tag.Get("apivalidator"), ",")
+
+		for _, tagArg := range tagArgs {
+			tagTokens := strings.SplitN(tagArg, "=", 2)
+			if len(tagTokens) < 2 && tagTokens[0] != "required" {
+				return nil, fmt.Errorf("field %s: apivalidator rule %q requires a value", fieldName, tagArg)
+			}
+			switch tagTokens[0] {
+			case "required":
+				v.Required = true
+			case "paramname":
+				v.ParamName = tagTokens[1]
+			case "enum":
+				v.Enum = strings.Split(tagTokens[1], "|")
+			case "default":
+				v.Default = tagTokens[1]
+			case "min":
+				num, err := strconv.ParseInt(tagTokens[1], 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("field %s: bad min value %q", fieldName, tagTokens[1])
+				}
+				v.Min = &num
+			case "max":
+				num, err := strconv.ParseInt(tagTokens[1], 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("field %s: bad max value %q", fieldName, tagTokens[1])
+				}
+				v.Max = num
+			case "max_size":
+				size, err := parseSize(tagTokens[1])
+				if err != nil {
+					return nil, fmt.Errorf("field %s: bad max_size value: %s", fieldName, err)
+				}
+				v.MaxSize = size
+				v.MaxSizeRaw = tagTokens[1]
+			case "mime":
+				v.Mime = strings.Split(tagTokens[1], "|")
+			default:
+				return nil, fmt.Errorf("field %s: unknown apivalidator rule %q", fieldName, tagTokens[0])
+			}
+		}
+
+		if v.IsFile() && (len(v.Enum) > 0 || v.Default != "" || v.Min != nil || v.Max > 0) {
+			return nil, fmt.Errorf("field %s: enum, default, min and max rules are not supported for files", fieldName)
+		}
+		if !v.IsFile() && (v.MaxSize > 0 || len(v.Mime) > 0) {
+			return nil, fmt.Errorf("field %s: max_size and mime rules are supported only for files", fieldName)
+		}
+
+		vp = append(vp, v)
+	}
+
+	return vp, nil
+}
+
+const defaultMaxMemory = 32 << 20
+
+// multipartOverhead is allowed over the sizes of files for boundaries, part headers and other fields.
+const multipartOverhead = 64 << 10
+
+// maxBodySize returns the limit of multipart bodies with the params, 0 if a file has no max_size.
+func maxBodySize(vp []*validateParams) int64 {
+	res := int64(multipartOverhead)
+	for _, v := range vp {
+		if !v.IsFile() {
+			continue
+		}
+		if v.MaxSize == 0 {
+			return 0
+		}
+		res += v.MaxSize
+	}
+	return res
+}
+
+// parseSize parses sizes like 512, 64KB, 5MB or 1GB.
+func parseSize(s string) (int64, error) {
+	mult := int64(1)
+	num := strings.ToUpper(strings.TrimSpace(s))
+	for _, u := range []struct {
+		suffix string
+		mult   int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(num, u.suffix) {
+			num, mult = strings.TrimSuffix(num, u.suffix), u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(num), 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("bad size %q", s)
+	}
+	return n * mult, nil
+}
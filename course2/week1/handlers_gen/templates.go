@@ -0,0 +1,630 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// Default templates of the generated code. Any of them can be overridden with
+// a <name>.tmpl file in the -templates directory, e.g. handlerTpl.tmpl. Besides
+// templateFuncs they may use funcs added with registerTemplateFunc.
+//
+// Templates receive the following data, which is kept stable between releases:
+//
+//	imports            []string, sorted import paths
+//	resEnvelope        nil
+//	i18nTpl            i18nTplParams
+//	handlerTpl         handlerTplParams, executed once per apigen:api annotation
+//	httpTpl            httpTplParams, executed once per struct
+//	etagHelpers, corsHelpers, idempotencyHelpers, multipartHelpers,
+//	metricsHelpers     nil, rendered once if any handler needs them
+//	tsTpl              tsTplParams, only with -ts
+//	cliTpl             cliTplParams, only with -cli
+//	mocksTpl           mocksTplParams, only with -mocks
+//	validationTestsTpl validationTestsTplParams, only with -tests
+//
+// These types, the types they refer to, like codegenParams, corsParams and
+// validateParams, and their methods make up the template data model. Fields and
+// methods of it are only added, never renamed or removed.
+
+const (
+	handlerTpl = `
+func (h *{{.StructName}}) {{.HandlerName}}(w http.ResponseWriter, r *http.Request) {
+	{{if .Metrics}}sw, outcome := apiMetrics.Begin("{{.StructName}}", "{{.HandlerName}}", w)
+	w = sw
+	defer func() { apiMetrics.End(sw, outcome) }()
+	{{end}}{{if .Deprecated}}w.Header().Set("Deprecation", "true")
+	w.Header().Set("Sunset", "{{.Sunset}}")
+	log.Printf("deprecated endpoint {{.StructName}}.{{.MethodName}} called: %s %s, sunset {{.Deprecated}}", r.Method, r.URL.Path)
+	{{end}}{{if ne .HttpMethod ""}}if r.Method != "{{.HttpMethod}}" {
+		{{if .Metrics}}outcome = "bad_method"
+		{{end}}w.WriteHeader(http.StatusNotAcceptable)
+		_, _ = w.Write(apiErrorBody("bad method"))
+		return
+	}
+	{{end}}{{if .Auth}}if strings.Compare(r.Header.Get("X-Auth"), "100500") != 0 {
+		{{if .Metrics}}outcome = "unauthorized"
+		{{end}}w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write(apiErrorBody("unauthorized"))
+		return
+	}
+	{{end}}{{if .ParamTypeName}}params := {{.ParamTypeName}}{}
+	{{if .HasFiles}}{{if .MaxBody}}r.Body = http.MaxBytesReader(w, r.Body, {{.MaxBody}})
+	{{end}}if err := r.ParseMultipartForm({{.MaxMemory}}); err != nil && err != http.ErrNotMultipart {
+		{{if .Metrics}}outcome = "validation"
+		{{end}}var mbe *http.MaxBytesError
+		if errors.As(err, &mbe) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			_, _ = w.Write(apiErrorBody(apiValidationMessage(r, "body_size", "", "{{.MaxBody}}")))
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write(apiErrorBody(apiValidationMessage(r, "multipart", "", "")))
+		return
+	}
+	{{end}}	{{range $f := .ValidateParams}}
+	{{$f.GetValueFromRequest $.HttpMethod}}
+	{{$f.GetValidation}}
+	params.{{$f.FieldName}} = raw{{$f.FieldName}}
+	{{end}}{{end}}{{if .Idempotent}}
+	idemKey := r.Header.Get("Idempotency-Key")
+	idemFingerprint := ""
+	if idemKey != "" {
+		idemKey = apiIdempotencyKey("{{.StructName}}.{{.MethodName}}", r.Header.Get("X-Auth"), idemKey)
+		idemFingerprint = apiFingerprint({{if .ParamTypeName}}params{{else}}nil{{end}})
+		rec, inFlight := ApiIdempotencyStore.Reserve(idemKey)
+		switch {
+		case rec != nil && rec.Fingerprint != idemFingerprint:
+			{{if .Metrics}}outcome = "idempotency_conflict"
+			{{end}}w.WriteHeader(http.StatusUnprocessableEntity)
+			_, _ = w.Write(apiErrorBody("idempotency key reused with different params"))
+			return
+		case rec != nil:
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(rec.Status)
+			_, _ = w.Write(rec.Body)
+			return
+		case inFlight:
+			{{if .Metrics}}outcome = "idempotency_in_flight"
+			{{end}}w.WriteHeader(http.StatusConflict)
+			_, _ = w.Write(apiErrorBody("request with this idempotency key is in progress"))
+			return
+		}
+		// the key is reserved until the response is stored, or released if it is not
+		defer ApiIdempotencyStore.Release(idemKey)
+	}
+	{{end}}
+	ctx := context.Background()
+	{{if .HasResult}}res, err{{else}}err{{end}} := {{.Target}}.{{.MethodName}}(ctx{{if .ParamTypeName}}, {{if .ParamPointer}}&{{end}}params{{end}})
+	if err != nil {
+		c := http.StatusInternalServerError
+		e := err.Error()
+		if err, ok := err.(ApiError); ok {
+			c = err.HTTPStatus
+		}
+		{{if .Metrics}}outcome = "method_error"
+		{{end}}w.WriteHeader(c)
+		rb := apiErrorBody(e)
+		{{if .Idempotent}}if idemKey != "" && c < http.StatusInternalServerError {
+			ApiIdempotencyStore.Put(idemKey, &IdempotencyRecord{idemFingerprint, c, rb})
+		}
+		{{end}}_, _ = w.Write(rb)
+		return
+	}
+	rb := apiResponseBody({{if .HasResult}}res{{else}}nil{{end}})
+	{{if .Idempotent}}if idemKey != "" {
+		ApiIdempotencyStore.Put(idemKey, &IdempotencyRecord{idemFingerprint, http.StatusOK, rb})
+	}
+	{{end}}	{{if .Etag}}if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		etag := apiETag(rb)
+		w.Header().Set("ETag", etag)
+		{{if gt .MaxAge 0}}w.Header().Set("Cache-Control", "max-age={{.MaxAge}}")
+		{{end}}if apiETagMatch(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	{{end}}w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(rb)
+}
+`
+
+	httpTpl = `
+func (h *{{.StructName}}) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	{{with .Cors}}w.Header().Add("Vary", "Origin")
+	origin := r.Header.Get("Origin")
+	corsAllowed := origin != "" && apiCorsAllowOrigin(origin, []string{ {{range $i, $o := .Origins}}{{if $i}}, {{end}}{{printf "%q" $o}}{{end}} })
+	if corsAllowed {
+		{{if .AnyOrigin}}w.Header().Set("Access-Control-Allow-Origin", "*"){{else}}w.Header().Set("Access-Control-Allow-Origin", origin){{end}}
+		{{if .Credentials}}w.Header().Set("Access-Control-Allow-Credentials", "true")
+		{{end}}{{if .Expose}}w.Header().Set("Access-Control-Expose-Headers", "{{range $i, $h := .Expose}}{{if $i}}, {{end}}{{$h}}{{end}}")
+		{{end}}
+	}
+	if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+		// preflights of unknown paths get 404 below
+		switch r.URL.Path {
+		case {{range $i, $cp := $.CodegenParams}}{{if $i}}, {{end}}"{{$cp.Path}}"{{end}}:
+			if corsAllowed {
+				w.Header().Set("Access-Control-Allow-Methods", "{{.AllowMethods $.CodegenParams}}")
+				{{if .Headers}}w.Header().Set("Access-Control-Allow-Headers", "{{range $i, $h := .Headers}}{{if $i}}, {{end}}{{$h}}{{end}}")
+				{{end}}{{if gt .MaxAge 0}}w.Header().Set("Access-Control-Max-Age", "{{.MaxAge}}")
+				{{end}}
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+	{{end}}switch r.URL.Path {
+	{{range $cp := .CodegenParams}}case "{{$cp.Path}}":
+		h.{{$cp.FuncName}}(w, r)
+	{{end}}default:
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write(apiErrorBody("unknown method"))
+	}
+}
+`
+
+	i18nTpl = `
+var apiValidationMessages = map[string]map[string]string{
+	{{range $locale, $messages := .Messages}}{{printf "%q" $locale}}: {
+		{{range $rule, $msg := $messages}}{{printf "%q" $rule}}: {{printf "%q" $msg}},
+		{{end}}},
+	{{end}}}
+
+const apiDefaultLocale = {{printf "%q" .DefaultLocale}}
+
+func apiLocale(r *http.Request) string {
+	best, bestQ := apiDefaultLocale, 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, q := strings.TrimSpace(part), 1.0
+		if i := strings.Index(tag, ";"); i >= 0 {
+			if v, err := strconv.ParseFloat(strings.TrimPrefix(strings.TrimSpace(tag[i+1:]), "q="), 64); err == nil {
+				q = v
+			}
+			tag = strings.TrimSpace(tag[:i])
+		}
+		if i := strings.Index(tag, "-"); i >= 0 {
+			tag = tag[:i]
+		}
+		tag = strings.ToLower(tag)
+		if _, ok := apiValidationMessages[tag]; ok && q > bestQ {
+			best, bestQ = tag, q
+		}
+	}
+	return best
+}
+
+func apiValidationMessage(r *http.Request, rule, param, value string) string {
+	msg, ok := apiValidationMessages[apiLocale(r)][rule]
+	if !ok {
+		msg = apiValidationMessages[apiDefaultLocale][rule]
+	}
+	return strings.NewReplacer("{param}", param, "{value}", value).Replace(msg)
+}
+`
+
+	// resEnvelope defines the bodies of all responses, an override has to keep
+	// apiErrorBody and apiResponseBody.
+	resEnvelope = `
+type ResponseEnvelope struct {
+	Error string ` + "`json:\"error\"`" + `
+	Response interface{} ` + "`json:\"response,omitempty\"`" + `
+}
+
+// apiErrorBody returns the body of an error response.
+func apiErrorBody(msg string) []byte {
+	rb, _ := json.Marshal(&ResponseEnvelope{Error: msg})
+	return rb
+}
+
+// apiResponseBody returns the body of a successful response, res is nil for methods without result.
+func apiResponseBody(res interface{}) []byte {
+	rb, _ := json.Marshal(&ResponseEnvelope{Response: res})
+	return rb
+}
+`
+	etagHelpers = `
+func apiETag(b []byte) string {
+	sum := sha1.Sum(b)
+	return "\"" + hex.EncodeToString(sum[:]) + "\""
+}
+
+func apiETagMatch(header, etag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == "*" || t == etag {
+			return true
+		}
+	}
+	return false
+}
+`
+
+	corsHelpers = `
+func apiCorsAllowOrigin(origin string, allowed []string) bool {
+	for _, o := range allowed {
+		if o == "*" || o == origin {
+			return true
+		}
+	}
+	return false
+}
+`
+
+	idempotencyHelpers = `
+// IdempotencyRecord is the first response produced for an Idempotency-Key.
+type IdempotencyRecord struct {
+	Fingerprint string
+	Status      int
+	Body        []byte
+}
+
+// IdempotencyStore keeps responses of idempotent endpoints, it must be safe for concurrent use.
+type IdempotencyStore interface {
+	// Reserve returns the record of key. If there is none, it atomically reserves key for
+	// the caller and reports inFlight false, or true if key is already reserved by another request.
+	Reserve(key string) (rec *IdempotencyRecord, inFlight bool)
+	// Put stores the record of a reserved key and ends the reservation.
+	Put(key string, rec *IdempotencyRecord)
+	// Release ends the reservation of key if no record was put, so the request can be retried.
+	Release(key string)
+}
+
+// ApiIdempotencyStore is used by the generated handlers, replace it to plug in another store.
+var ApiIdempotencyStore IdempotencyStore = NewLRUIdempotencyStore(1024)
+
+type lruIdempotencyEntry struct {
+	key string
+	rec *IdempotencyRecord
+}
+
+// LRUIdempotencyStore is an in-memory IdempotencyStore which evicts least recently used keys.
+// Reserved keys are not evicted.
+type LRUIdempotencyStore struct {
+	mu       sync.Mutex
+	capacity int
+	items    map[string]*list.Element
+	order    *list.List
+	reserved map[string]bool
+}
+
+func NewLRUIdempotencyStore(capacity int) *LRUIdempotencyStore {
+	return &LRUIdempotencyStore{
+		capacity: capacity,
+		items:    make(map[string]*list.Element),
+		order:    list.New(),
+		reserved: make(map[string]bool),
+	}
+}
+
+func (s *LRUIdempotencyStore) Reserve(key string) (*IdempotencyRecord, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.items[key]; ok {
+		s.order.MoveToFront(e)
+		return e.Value.(*lruIdempotencyEntry).rec, false
+	}
+	if s.reserved[key] {
+		return nil, true
+	}
+	s.reserved[key] = true
+	return nil, false
+}
+
+func (s *LRUIdempotencyStore) Release(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.reserved, key)
+}
+
+func (s *LRUIdempotencyStore) Put(key string, rec *IdempotencyRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.reserved, key)
+	if e, ok := s.items[key]; ok {
+		e.Value.(*lruIdempotencyEntry).rec = rec
+		s.order.MoveToFront(e)
+		return
+	}
+	s.items[key] = s.order.PushFront(&lruIdempotencyEntry{key, rec})
+	if s.order.Len() > s.capacity {
+		e := s.order.Back()
+		s.order.Remove(e)
+		delete(s.items, e.Value.(*lruIdempotencyEntry).key)
+	}
+}
+
+// apiIdempotencyKey scopes a client key to the endpoint and the caller, so keys of different callers do not collide.
+func apiIdempotencyKey(endpoint, auth, key string) string {
+	b, _ := json.Marshal([]string{endpoint, auth, key})
+	return string(b)
+}
+
+func apiFingerprint(params interface{}) string {
+	b, _ := json.Marshal(params)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+`
+
+	multipartHelpers = `
+func apiFormFile(r *http.Request, name string) *multipart.FileHeader {
+	if r.MultipartForm == nil || len(r.MultipartForm.File[name]) < 1 {
+		return nil
+	}
+	return r.MultipartForm.File[name][0]
+}
+
+func apiFileMimeIn(fh *multipart.FileHeader, types ...string) bool {
+	f, err := fh.Open()
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(f, head)
+	ct := http.DetectContentType(head[:n])
+	if i := strings.Index(ct, ";"); i >= 0 {
+		ct = ct[:i]
+	}
+	for _, t := range types {
+		if t == ct {
+			return true
+		}
+	}
+	return false
+}
+
+func apiReadFile(fh *multipart.FileHeader) ([]byte, error) {
+	f, err := fh.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
+`
+
+	metricsHelpers = `
+var apiLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type apiHandlerKey struct {
+	api, handler string
+}
+
+type apiRequestKey struct {
+	api, handler, code, outcome string
+}
+
+type apiHandlerMetrics struct {
+	inFlight int64
+	buckets  []uint64
+	count    uint64
+	sum      float64
+}
+
+type apiMetricsRegistry struct {
+	mu       sync.Mutex
+	requests map[apiRequestKey]uint64
+	handlers map[apiHandlerKey]*apiHandlerMetrics
+}
+
+var apiMetrics = &apiMetricsRegistry{
+	requests: make(map[apiRequestKey]uint64),
+	handlers: make(map[apiHandlerKey]*apiHandlerMetrics),
+}
+
+type apiStatusWriter struct {
+	http.ResponseWriter
+	key    apiHandlerKey
+	status int
+	start  time.Time
+}
+
+func (w *apiStatusWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Flush implements http.Flusher when the underlying writer does.
+func (w *apiStatusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker, the status of a hijacked connection is left as it was.
+func (w *apiStatusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("%T does not implement http.Hijacker", w.ResponseWriter)
+	}
+	return h.Hijack()
+}
+
+func (m *apiMetricsRegistry) handler(key apiHandlerKey) *apiHandlerMetrics {
+	hm, ok := m.handlers[key]
+	if !ok {
+		hm = &apiHandlerMetrics{buckets: make([]uint64, len(apiLatencyBuckets))}
+		m.handlers[key] = hm
+	}
+	return hm
+}
+
+func (m *apiMetricsRegistry) Begin(api, handler string, w http.ResponseWriter) (*apiStatusWriter, string) {
+	key := apiHandlerKey{api, handler}
+	m.mu.Lock()
+	m.handler(key).inFlight++
+	m.mu.Unlock()
+	return &apiStatusWriter{ResponseWriter: w, key: key, status: http.StatusOK, start: time.Now()}, "success"
+}
+
+func (m *apiMetricsRegistry) End(w *apiStatusWriter, outcome string) {
+	d := time.Since(w.start).Seconds()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	hm := m.handler(w.key)
+	hm.inFlight--
+	hm.count++
+	hm.sum += d
+	for i, b := range apiLatencyBuckets {
+		if d <= b {
+			hm.buckets[i]++
+		}
+	}
+	m.requests[apiRequestKey{w.key.api, w.key.handler, strconv.Itoa(w.status), outcome}]++
+}
+
+func (m *apiMetricsRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	requests := make([]apiRequestKey, 0, len(m.requests))
+	for k := range m.requests {
+		requests = append(requests, k)
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		a, b := requests[i], requests[j]
+		if a.api != b.api {
+			return a.api < b.api
+		}
+		if a.handler != b.handler {
+			return a.handler < b.handler
+		}
+		if a.code != b.code {
+			return a.code < b.code
+		}
+		return a.outcome < b.outcome
+	})
+	handlers := make([]apiHandlerKey, 0, len(m.handlers))
+	for k := range m.handlers {
+		handlers = append(handlers, k)
+	}
+	sort.Slice(handlers, func(i, j int) bool {
+		if handlers[i].api != handlers[j].api {
+			return handlers[i].api < handlers[j].api
+		}
+		return handlers[i].handler < handlers[j].handler
+	})
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	fmt.Fprintln(w, "# HELP api_requests_total Total number of handled API requests.")
+	fmt.Fprintln(w, "# TYPE api_requests_total counter")
+	for _, k := range requests {
+		fmt.Fprintf(w, "api_requests_total{api=%q,handler=%q,code=%q,outcome=%q} %d\n", k.api, k.handler, k.code, k.outcome, m.requests[k])
+	}
+	fmt.Fprintln(w, "# HELP api_request_duration_seconds API request latency.")
+	fmt.Fprintln(w, "# TYPE api_request_duration_seconds histogram")
+	for _, k := range handlers {
+		hm := m.handlers[k]
+		for i, b := range apiLatencyBuckets {
+			fmt.Fprintf(w, "api_request_duration_seconds_bucket{api=%q,handler=%q,le=%q} %d\n", k.api, k.handler, strconv.FormatFloat(b, 'g', -1, 64), hm.buckets[i])
+		}
+		fmt.Fprintf(w, "api_request_duration_seconds_bucket{api=%q,handler=%q,le=\"+Inf\"} %d\n", k.api, k.handler, hm.count)
+		fmt.Fprintf(w, "api_request_duration_seconds_sum{api=%q,handler=%q} %s\n", k.api, k.handler, strconv.FormatFloat(hm.sum, 'g', -1, 64))
+		fmt.Fprintf(w, "api_request_duration_seconds_count{api=%q,handler=%q} %d\n", k.api, k.handler, hm.count)
+	}
+	fmt.Fprintln(w, "# HELP api_requests_in_flight Number of API requests being handled.")
+	fmt.Fprintln(w, "# TYPE api_requests_in_flight gauge")
+	for _, k := range handlers {
+		fmt.Fprintf(w, "api_requests_in_flight{api=%q,handler=%q} %d\n", k.api, k.handler, m.handlers[k].inFlight)
+	}
+}
+
+// MetricsHandler exposes metrics of the generated handlers in the Prometheus text format.
+func MetricsHandler() http.Handler {
+	return apiMetrics
+}
+`
+
+	imports = `
+import (
+{{range .}}	"{{.}}"
+{{end}})
+`
+)
+
+var defaultTemplates = map[string]string{
+	"handlerTpl":         handlerTpl,
+	"httpTpl":            httpTpl,
+	"i18nTpl":            i18nTpl,
+	"resEnvelope":        resEnvelope,
+	"etagHelpers":        etagHelpers,
+	"corsHelpers":        corsHelpers,
+	"idempotencyHelpers": idempotencyHelpers,
+	"multipartHelpers":   multipartHelpers,
+	"metricsHelpers":     metricsHelpers,
+	"imports":            imports,
+	"tsTpl":              tsTpl,
+	"cliTpl":             cliTpl,
+	"mocksTpl":           mocksTpl,
+	"validationTestsTpl": validationTestsTpl,
+}
+
+// templateFuncs is the FuncMap available in all templates.
+var templateFuncs = template.FuncMap{
+	"lowerFirst": lowerFirst,
+	"upperFirst": upperFirst,
+	"quote":      strconv.Quote,
+	"join":       strings.Join,
+}
+
+// registerTemplateFunc adds fn to the FuncMap of the templates as name. Own helpers are
+// registered from an init function in a file put next to codegen.go, e.g.
+//
+//	func init() {
+//		registerTemplateFunc("jsonTag", func(name string) string { return "`json:\"" + name + "\"`" })
+//	}
+//
+// so the generator itself is not changed. It must not be called after loadTemplates.
+func registerTemplateFunc(name string, fn interface{}) {
+	templateFuncs[name] = fn
+}
+
+// templates is the set used to render the generated code, see loadTemplates.
+var templates *template.Template
+
+// loadTemplates parses default templates and then *.tmpl files from dir, if any.
+// A file named as a default template replaces it, other files may define helper templates.
+func loadTemplates(dir string) (*template.Template, error) {
+	t := template.New("codegen").Funcs(templateFuncs)
+
+	names := make([]string, 0, len(defaultTemplates))
+	for name := range defaultTemplates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, err := t.New(name).Parse(defaultTemplates[name]); err != nil {
+			return nil, fmt.Errorf("template %s: %s", name, err)
+		}
+	}
+
+	if dir == "" {
+		return t, nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.tmpl"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no *.tmpl files in %s", dir)
+	}
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		name := strings.TrimSuffix(filepath.Base(f), ".tmpl")
+		if _, err := t.New(name).Parse(string(b)); err != nil {
+			return nil, fmt.Errorf("template %s: %s", f, err)
+		}
+	}
+	return t, nil
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/types"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type tsField struct {
+	Name     string
+	Type     string
+	Optional bool
+}
+
+// Key returns the field name quoted if it is not a valid identifier.
+func (f *tsField) Key() string {
+	for i, r := range f.Name {
+		if !(r == '_' || r == '$' || unicode.IsLetter(r) || (i > 0 && unicode.IsDigit(r))) {
+			return strconv.Quote(f.Name)
+		}
+	}
+	return f.Name
+}
+
+type tsInterface struct {
+	Name    string
+	Extends []string
+	Fields  []*tsField
+}
+
+type tsFunction struct {
+	Name       string
+	Url        string
+	HttpMethod string
+	Auth       bool
+	Multipart  bool
+	ParamsType string
+	ResultType string
+}
+
+// tsTplParams is the data of tsTpl.
+type tsTplParams struct {
+	Source     string
+	Interfaces []*tsInterface
+	Functions  []*tsFunction
+}
+
+const tsTpl = `// Code generated by codegen from {{.Source}}; DO NOT EDIT.
+
+export interface ApiClientOptions {
+  baseUrl: string;
+  auth?: string;
+  headers?: Record<string, string>;
+  fetch?: typeof fetch;
+}
+
+export class ApiError extends Error {
+  readonly status: number;
+
+  constructor(status: number, message: string) {
+    super(message);
+    this.name = "ApiError";
+    this.status = status;
+  }
+}
+{{range .Interfaces}}
+export interface {{.Name}}{{if .Extends}} extends {{range $i, $e := .Extends}}{{if $i}}, {{end}}{{$e}}{{end}}{{end}} {
+{{range .Fields}}  {{.Key}}{{if .Optional}}?{{end}}: {{.Type}};
+{{end}}}
+{{end}}
+async function apiCall<T>(opts: ApiClientOptions, url: string, method: string, auth: boolean, multipart: boolean, params?: object): Promise<T> {
+  const headers: Record<string, string> = { ...opts.headers };
+  if (auth && opts.auth !== undefined) {
+    headers["X-Auth"] = opts.auth;
+  }
+  let target = opts.baseUrl + url;
+  let body: FormData | URLSearchParams | undefined;
+  if (multipart) {
+    const form = new FormData();
+    for (const [k, v] of Object.entries(params || {})) {
+      if (v !== undefined) {
+        form.append(k, v instanceof Blob ? v : String(v));
+      }
+    }
+    body = form;
+  } else {
+    const form = new URLSearchParams();
+    for (const [k, v] of Object.entries(params || {})) {
+      if (v !== undefined) {
+        form.append(k, String(v));
+      }
+    }
+    if (method === "GET") {
+      const query = form.toString();
+      if (query) {
+        target += "?" + query;
+      }
+    } else {
+      body = form;
+    }
+  }
+  const resp = await (opts.fetch || fetch)(target, { method, headers, body });
+  let data: { error?: string; response?: T };
+  try {
+    data = await resp.json();
+  } catch {
+    data = { error: resp.statusText };
+  }
+  if (!resp.ok || data.error) {
+    throw new ApiError(resp.status, data.error || resp.statusText);
+  }
+  return data.response as T;
+}
+{{range .Functions}}
+export function {{.Name}}(opts: ApiClientOptions{{if .ParamsType}}, params: {{.ParamsType}}{{end}}): Promise<{{.ResultType}}> {
+  return apiCall<{{.ResultType}}>(opts, "{{.Url}}", "{{.HttpMethod}}", {{.Auth}}, {{.Multipart}}{{if .ParamsType}}, params{{end}});
+}
+{{end}}`
+
+// tsKey is an interface of a struct in a role, params and results of the same struct differ
+type tsKey struct {
+	Params bool
+	Name   string
+}
+
+type tsGenerator struct {
+	structs    map[string]*ast.StructType
+	paramTypes map[string]bool
+	seen       map[tsKey]bool
+	interfaces []*tsInterface
+}
+
+func generateTypeScript(source string, methods []*apiMethod, structs map[string]*ast.StructType) ([]byte, error) {
+	g := &tsGenerator{
+		structs:    structs,
+		paramTypes: make(map[string]bool),
+		seen:       make(map[tsKey]bool),
+	}
+	params := tsTplParams{Source: filepath.Base(source)}
+
+	for _, m := range methods {
+		g.paramTypes[m.Handler.ParamTypeName] = true
+	}
+	for _, m := range methods {
+		h := m.Handler
+		fn := &tsFunction{
+			Name:       lowerFirst(h.StructName) + strings.TrimPrefix(h.HandlerName, "handler"),
+			Url:        h.Url,
+			HttpMethod: h.HttpMethod,
+			Auth:       h.Auth,
+			Multipart:  h.HasFiles,
+			ParamsType: h.ParamTypeName,
+			ResultType: "void",
+		}
+		if fn.HttpMethod == "" {
+			fn.HttpMethod = "GET"
+			if h.HasFiles {
+				fn.HttpMethod = "POST"
+			}
+		}
+
+		if key := (tsKey{true, h.ParamTypeName}); h.ParamTypeName != "" && !g.seen[key] {
+			g.seen[key] = true
+			g.interfaces = append(g.interfaces, tsParamsInterface(h.ParamTypeName, h.ValidateParams))
+		}
+
+		if m.Signature.HasResult {
+			rt := m.Signature.ResultType
+			if se, ok := rt.(*ast.StarExpr); ok {
+				rt = se.X
+			}
+			t, err := g.tsType(rt)
+			if err != nil {
+				return nil, fmt.Errorf("method %s.%s result: %s", h.StructName, h.MethodName, err)
+			}
+			fn.ResultType = t
+		}
+
+		params.Functions = append(params.Functions, fn)
+	}
+	params.Interfaces = g.interfaces
+
+	buf := &bytes.Buffer{}
+	if err := templates.ExecuteTemplate(buf, "tsTpl", params); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// tsParamsInterface describes params as they are sent in a request, so paramname is used for field names.
+func tsParamsInterface(name string, vps []*validateParams) *tsInterface {
+	res := &tsInterface{Name: name}
+	for _, vp := range vps {
+		f := &tsField{
+			Name:     vp.ParamName,
+			Optional: !vp.Required || vp.Default != "",
+		}
+		switch {
+		case vp.IsFile():
+			f.Type = "Blob"
+		case len(vp.Enum) > 0:
+			values := make([]string, len(vp.Enum))
+			for i, v := range vp.Enum {
+				values[i] = strconv.Quote(v)
+			}
+			f.Type = strings.Join(values, " | ")
+		case vp.FieldType == "int":
+			f.Type = "number"
+		default:
+			f.Type = "string"
+		}
+		res.Fields = append(res.Fields, f)
+	}
+	return res
+}
+
+func (g *tsGenerator) tsType(expr ast.Expr) (string, error) {
+	switch t := expr.(type) {
+	case *ast.Ident:
+		switch t.Name {
+		case "string":
+			return "string", nil
+		case "bool":
+			return "boolean", nil
+		case "int", "int8", "int16", "int32", "int64",
+			"uint", "uint8", "uint16", "uint32", "uint64",
+			"float32", "float64", "byte", "rune":
+			return "number", nil
+		}
+		st, ok := g.structs[t.Name]
+		if !ok {
+			return "", fmt.Errorf("unsupported type %s", t.Name)
+		}
+		name := t.Name
+		if g.paramTypes[name] {
+			// the name is taken by the interface of the params
+			name += "Result"
+		}
+		if err := g.addStruct(name, st); err != nil {
+			return "", err
+		}
+		return name, nil
+	case *ast.StarExpr:
+		et, err := g.tsType(t.X)
+		if err != nil {
+			return "", err
+		}
+		return et + " | null", nil
+	case *ast.ArrayType:
+		if id, ok := t.Elt.(*ast.Ident); ok && (id.Name == "byte" || id.Name == "uint8") {
+			return "string", nil
+		}
+		et, err := g.tsType(t.Elt)
+		if err != nil {
+			return "", err
+		}
+		if strings.Contains(et, " ") {
+			et = "(" + et + ")"
+		}
+		return et + "[]", nil
+	case *ast.MapType:
+		vt, err := g.tsType(t.Value)
+		if err != nil {
+			return "", err
+		}
+		return "Record<string, " + vt + ">", nil
+	case *ast.InterfaceType:
+		return "unknown", nil
+	case *ast.SelectorExpr:
+		if types.ExprString(t) == "time.Time" {
+			return "string", nil
+		}
+	}
+	return "", fmt.Errorf("unsupported type %s", types.ExprString(expr))
+}
+
+func (g *tsGenerator) addStruct(name string, st *ast.StructType) error {
+	if g.seen[tsKey{false, name}] {
+		return nil
+	}
+	g.seen[tsKey{false, name}] = true
+
+	res := &tsInterface{Name: name}
+	g.interfaces = append(g.interfaces, res)
+
+	for _, field := range st.Fields.List {
+		jsonName, omitempty := "", false
+		if field.Tag != nil {
+			tag := reflect.StructTag(field.Tag.Value[1 : len(field.Tag.Value)-1])
+			opts := strings.Split(tag.Get("json"), ",")
+			if opts[0] == "-" && len(opts) == 1 {
+				continue
+			}
+			jsonName = opts[0]
+			for _, o := range opts[1:] {
+				omitempty = omitempty || o == "omitempty"
+			}
+		}
+
+		if len(field.Names) == 0 {
+			id, ok := field.Type.(*ast.Ident)
+			if !ok || jsonName != "" {
+				return fmt.Errorf("struct %s: unsupported embedded field %s", name, types.ExprString(field.Type))
+			}
+			et, err := g.tsType(id)
+			if err != nil {
+				return err
+			}
+			res.Extends = append(res.Extends, et)
+			continue
+		}
+
+		var names []string
+		for _, n := range field.Names {
+			if n.IsExported() {
+				names = append(names, n.Name)
+			}
+		}
+		if len(names) == 0 {
+			// not encoded, so its type does not matter
+			continue
+		}
+		t, err := g.tsType(field.Type)
+		if err != nil {
+			return fmt.Errorf("struct %s: %s", name, err)
+		}
+		for _, n := range names {
+			fn := jsonName
+			if fn == "" {
+				fn = n
+			}
+			res.Fields = append(res.Fields, &tsField{fn, t, omitempty})
+		}
+	}
+	return nil
+}
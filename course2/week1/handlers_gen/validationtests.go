@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type validationCase struct {
+	Name       string
+	HttpMethod string
+	Url        string
+	Auth       bool
+	Params     string // url.Values literal
+	Status     string
+	Error      string
+	MethodName string
+	CheckField string // field of the recorded call to compare, e.g. for defaults
+	CheckValue string // Go literal the field is expected to be equal to
+}
+
+type validationApi struct {
+	StructName string
+	Cases      []*validationCase
+}
+
+// validationTestsTplParams is the data of validationTestsTpl.
+type validationTestsTplParams struct {
+	Source  string
+	Package string
+	Apis    []*validationApi
+}
+
+const validationTestsTpl = `// Code generated by codegen from {{.Source}}; DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+type apiValidationCase struct {
+	Name       string
+	HttpMethod string
+	Url        string
+	Auth       bool
+	Params     url.Values
+	Status     int
+	Error      string
+	Check      func(t *testing.T)
+}
+
+func runApiValidationCases(t *testing.T, baseURL string, cases []apiValidationCase) {
+	for _, c := range cases {
+		c := c
+		t.Run(c.Name, func(t *testing.T) {
+			target, body := baseURL+c.Url, ""
+			if c.HttpMethod == http.MethodGet {
+				target += "?" + c.Params.Encode()
+			} else {
+				body = c.Params.Encode()
+			}
+			req, err := http.NewRequest(c.HttpMethod, target, strings.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set("Accept-Language", "en")
+			if c.Auth {
+				req.Header.Set("X-Auth", "100500")
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			raw, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if resp.StatusCode != c.Status {
+				t.Fatalf("bad status code, got %d, expected %d, body %s", resp.StatusCode, c.Status, raw)
+			}
+			var res struct {
+				Error string ` + "`json:\"error\"`" + `
+			}
+			if err := json.Unmarshal(raw, &res); err != nil {
+				t.Fatalf("cant unpack json %s: %s", raw, err)
+			}
+			if res.Error != c.Error {
+				t.Fatalf("bad error, got %q, expected %q", res.Error, c.Error)
+			}
+			if c.Check != nil {
+				c.Check(t)
+			}
+		})
+	}
+}
+{{range .Apis}}
+func Test{{.StructName}}Validation(t *testing.T) {
+	mock := &{{.StructName}}ServiceMock{}
+	srv := New{{.StructName}}TestServer(mock)
+	defer srv.Close()
+
+	runApiValidationCases(t, srv.URL, []apiValidationCase{
+		{{range .Cases}}{
+			Name:       {{quote .Name}},
+			HttpMethod: {{quote .HttpMethod}},
+			Url:        {{quote .Url}},
+			Auth:       {{.Auth}},
+			Params:     {{.Params}},
+			Status:     {{.Status}},
+			Error:      {{quote .Error}},{{if .CheckField}}
+			Check: func(t *testing.T) {
+				calls := mock.{{.MethodName}}Calls
+				if len(calls) == 0 {
+					t.Fatal("{{.MethodName}} was not called")
+				}
+				if got := calls[len(calls)-1].{{.CheckField}}; got != {{.CheckValue}} {
+					t.Fatalf("bad {{.CheckField}}, got %v, expected %v", got, {{.CheckValue}})
+				}
+			},{{end}}
+		},
+		{{end}}})
+}
+{{end}}`
+
+// validationMessage renders a message of the default locale as the generated handlers do.
+func validationMessage(rule, param, value string) string {
+	return strings.NewReplacer("{param}", param, "{value}", value).Replace(validationMessages[defaultLocale][rule])
+}
+
+// accepts reports whether value passes the required, enum, min and max rules of the field.
+func (vp *validateParams) accepts(value string) bool {
+	if len(vp.Enum) > 0 {
+		found := false
+		for _, e := range vp.Enum {
+			found = found || e == value
+		}
+		if !found {
+			return false
+		}
+	}
+	if vp.FieldType == "int" {
+		n, err := strconv.ParseInt(value, 10, 64)
+		return err == nil && !(vp.Required && n == 0) &&
+			(vp.Min == nil || n >= *vp.Min) && (vp.Max == 0 || n <= vp.Max)
+	}
+	return len(value) >= 1 && (vp.Min == nil || int64(len(value)) >= *vp.Min)
+}
+
+// validParams returns a request that passes validation of every field.
+func validParams(vps []*validateParams) (url.Values, error) {
+	res := url.Values{}
+	for _, vp := range vps {
+		switch {
+		case len(vp.Enum) > 0:
+			ok := false
+			for _, e := range vp.Enum {
+				if vp.accepts(e) {
+					res.Set(vp.ParamName, e)
+					ok = true
+					break
+				}
+			}
+			if !ok {
+				return nil, fmt.Errorf("field %s: no value of the enum passes the other rules", vp.FieldName)
+			}
+		case vp.FieldType == "string":
+			n := int64(1)
+			if vp.Min != nil && *vp.Min > n {
+				n = *vp.Min
+			}
+			res.Set(vp.ParamName, strings.Repeat("a", int(n)))
+		case vp.FieldType == "int":
+			n := int64(1)
+			if vp.Min != nil && (*vp.Min > n || !vp.Required) {
+				n = *vp.Min
+			}
+			if vp.Max > 0 && n > vp.Max {
+				n = vp.Max
+			}
+			res.Set(vp.ParamName, strconv.FormatInt(n, 10))
+		}
+	}
+	return res, nil
+}
+
+func valuesLiteral(v url.Values) string {
+	keys := make([]string, 0, len(v))
+	for k := range v {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	res := make([]string, 0, len(v))
+	for _, k := range keys {
+		res = append(res, strconv.Quote(k)+": {"+strconv.Quote(v.Get(k))+"}")
+	}
+	return "url.Values{" + strings.Join(res, ", ") + "}"
+}
+
+// validationCases returns boundary cases of a handler: a valid request, then for
+// every field an empty required value, an out-of-enum value, min-1, max+1 and a
+// missing value that must be replaced with the default.
+func validationCases(h *handlerTplParams) ([]*validationCase, error) {
+	valid, err := validParams(h.ValidateParams)
+	if err != nil {
+		return nil, err
+	}
+	httpMethod := h.HttpMethod
+	if httpMethod == "" {
+		httpMethod = "GET"
+	}
+
+	var res []*validationCase
+	add := func(name string, params url.Values, rule, param, value string) *validationCase {
+		c := &validationCase{
+			Name:       h.HandlerName + "/" + name,
+			HttpMethod: httpMethod,
+			Url:        h.Url,
+			Auth:       h.Auth,
+			Params:     valuesLiteral(params),
+			Status:     "http.StatusBadRequest",
+			MethodName: h.MethodName,
+		}
+		if rule == "" {
+			c.Status = "http.StatusOK"
+		} else {
+			c.Error = validationMessage(rule, param, value)
+		}
+		res = append(res, c)
+		return c
+	}
+	with := func(param, value string) url.Values {
+		params := url.Values{}
+		for k, v := range valid {
+			params[k] = v
+		}
+		if value == "" {
+			params.Del(param)
+		} else {
+			params.Set(param, value)
+		}
+		return params
+	}
+
+	add("valid", valid, "", "", "")
+
+	for _, vp := range h.ValidateParams {
+		p := vp.ParamName
+		hasDefault := vp.Default != ""
+		if vp.FieldType == "int" {
+			dn, err := strconv.Atoi(vp.Default)
+			hasDefault = err == nil && dn > 0
+		}
+
+		if vp.Required {
+			add(p+" required", with(p, ""), "required", p, "")
+		}
+		if vp.FieldType == "int" {
+			add(p+" not int", with(p, "abc"), "int", p, "")
+		}
+		if len(vp.Enum) > 0 {
+			add(p+" out of enum", with(p, "not_"+vp.Enum[0]), "enum", p, strings.Join(vp.Enum, ", "))
+		}
+		if vp.Min != nil && len(vp.Enum) == 0 {
+			below := *vp.Min - 1
+			// an empty value is checked by the required and default rules instead
+			skip := below == 0 && (vp.Required || hasDefault)
+			switch {
+			case skip:
+			case vp.FieldType == "int":
+				n := strconv.FormatInt(below, 10)
+				add(p+" min-1", with(p, n), "min", p, strconv.FormatInt(*vp.Min, 10))
+			case below >= 0:
+				add(p+" min-1", with(p, strings.Repeat("a", int(below))), "min_len", p, strconv.FormatInt(*vp.Min, 10))
+			}
+		}
+		if vp.Max > 0 && vp.FieldType == "int" {
+			add(p+" max+1", with(p, strconv.FormatInt(vp.Max+1, 10)), "max", p, strconv.FormatInt(vp.Max, 10))
+		}
+		if hasDefault && !vp.Required {
+			// ints are always sent, their default replaces 0
+			missing := ""
+			if vp.FieldType == "int" {
+				missing = "0"
+			}
+			c := add(p+" default", with(p, missing), "", "", "")
+			c.CheckField = vp.FieldName
+			c.CheckValue = vp.Default
+			if vp.FieldType == "string" {
+				c.CheckValue = strconv.Quote(vp.Default)
+			}
+		}
+	}
+	return res, nil
+}
+
+// generateValidationTests generates table-driven tests of the validation rules of
+// every annotated method. The handlers are served over the generated mocks, so the
+// tests need the -mocks output in the same package. Methods with file fields are
+// skipped, their requests can not be expressed as url.Values.
+func generateValidationTests(source, pkg string, methods []*apiMethod) ([]byte, error) {
+	params := validationTestsTplParams{
+		Source:  filepath.Base(source),
+		Package: pkg,
+	}
+	apis := make(map[string]*validationApi)
+
+	for _, m := range methods {
+		h := m.Handler
+		if h.HasFiles || len(h.ValidateParams) == 0 {
+			continue
+		}
+		api, ok := apis[h.StructName]
+		if !ok {
+			api = &validationApi{StructName: h.StructName}
+			apis[h.StructName] = api
+			params.Apis = append(params.Apis, api)
+		}
+		cases, err := validationCases(h)
+		if err != nil {
+			return nil, fmt.Errorf("method %s.%s: %s", h.StructName, h.MethodName, err)
+		}
+		api.Cases = append(api.Cases, cases...)
+	}
+
+	if len(params.Apis) == 0 {
+		return nil, fmt.Errorf("no annotated methods with validated params found")
+	}
+
+	buf := &bytes.Buffer{}
+	if err := templates.ExecuteTemplate(buf, "validationTestsTpl", params); err != nil {
+		return nil, err
+	}
+	return format.Source(buf.Bytes())
+}
@@ -12,7 +12,10 @@ import (
 
 func main() {
 	// будет вызван метод ServeHTTP у структуры MyApi
-	http.Handle("/user/", NewMyApi())
+	api := NewMyApi()
+	http.Handle("/user/", api)
+	http.Handle("/v1/user/", api)
+	http.Handle("/v2/user/", api)
 
 	fmt.Println("starting server at :8080")
 	http.ListenAndServe(":8080", nil)
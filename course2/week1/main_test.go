@@ -1,13 +1,16 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -25,6 +28,7 @@ type Case struct {
 	Path   string
 	Query  string
 	Auth   bool
+	Header map[string]string
 	Status int
 	Result interface{}
 }
@@ -285,6 +289,591 @@ func TestOtherApi(t *testing.T) {
 	runTests(t, ts, cases)
 }
 
+func TestMyApiLocalized(t *testing.T) {
+	ts := httptest.NewServer(NewMyApi())
+
+	cases := []Case{
+		Case{ // русская локаль
+			Path:   ApiUserCreate,
+			Method: http.MethodPost,
+			Query:  "login=new_m&age=32&status=moderator&full_name=Ivan_Ivanov",
+			Status: http.StatusBadRequest,
+			Auth:   true,
+			Header: map[string]string{"Accept-Language": "ru-RU,ru;q=0.9,en;q=0.8"},
+			Result: CR{
+				"error": "длина login должна быть >= 10",
+			},
+		},
+		Case{ // предпочтение по q
+			Path:   ApiUserCreate,
+			Method: http.MethodPost,
+			Query:  "login=new_moderator&age=32&status=adm&full_name=Ivan_Ivanov",
+			Status: http.StatusBadRequest,
+			Auth:   true,
+			Header: map[string]string{"Accept-Language": "en;q=0.5, ru;q=0.7"},
+			Result: CR{
+				"error": "status должен быть одним из [user, moderator, admin]",
+			},
+		},
+		Case{ // неизвестная локаль - английский по-умолчанию
+			Path:   ApiUserProfile,
+			Query:  "",
+			Status: http.StatusBadRequest,
+			Header: map[string]string{"Accept-Language": "de-DE"},
+			Result: CR{
+				"error": "login must me not empty",
+			},
+		},
+	}
+
+	runTests(t, ts, cases)
+}
+
+func TestMyApiETag(t *testing.T) {
+	ts := httptest.NewServer(NewMyApi())
+
+	resp, err := client.Get(ts.URL + ApiUserProfile + "?login=rvasily")
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	resp.Body.Close()
+
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		t.Fatalf("expected ETag header")
+	}
+	if cc := resp.Header.Get("Cache-Control"); cc != "max-age=60" {
+		t.Errorf("expected Cache-Control max-age=60, got %q", cc)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+ApiUserProfile+"?login=rvasily", nil)
+	req.Header.Set("If-None-Match", etag)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("expected http status %v, got %v", http.StatusNotModified, resp.StatusCode)
+	}
+
+	// другой пользователь - другой ETag
+	runTests(t, ts, []Case{
+		Case{
+			Path:   ApiUserCreate,
+			Method: http.MethodPost,
+			Query:  "login=etag_moderator&age=32&status=moderator&full_name=Ivan_Ivanov",
+			Status: http.StatusOK,
+			Auth:   true,
+			Result: CR{
+				"error": "",
+				"response": CR{
+					"id": 43,
+				},
+			},
+		},
+	})
+	req, _ = http.NewRequest(http.MethodGet, ts.URL+ApiUserProfile+"?login=etag_moderator", nil)
+	req.Header.Set("If-None-Match", etag)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected http status %v, got %v", http.StatusOK, resp.StatusCode)
+	}
+	if other := resp.Header.Get("ETag"); other == "" || other == etag {
+		t.Errorf("expected ETag other than %s, got %q", etag, other)
+	}
+
+	// ETag только у GET и HEAD
+	req, _ = http.NewRequest(http.MethodPost, ts.URL+ApiUserProfile, strings.NewReader("login=rvasily"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("If-None-Match", etag)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected http status %v, got %v", http.StatusOK, resp.StatusCode)
+	}
+	if got := resp.Header.Get("ETag") + resp.Header.Get("Cache-Control"); got != "" {
+		t.Errorf("expected no ETag and Cache-Control for POST, got %q", got)
+	}
+}
+
+func TestMyApiIdempotency(t *testing.T) {
+	ts := httptest.NewServer(NewMyApi())
+
+	store := ApiIdempotencyStore
+	ApiIdempotencyStore = NewLRUIdempotencyStore(16)
+	defer func() { ApiIdempotencyStore = store }()
+
+	cases := []Case{
+		Case{ // первый запрос с ключом
+			Path:   ApiUserCreate,
+			Method: http.MethodPost,
+			Query:  "login=idempotent_user&age=32&full_name=Ivan_Ivanov",
+			Status: http.StatusOK,
+			Auth:   true,
+			Header: map[string]string{"Idempotency-Key": "create-1"},
+			Result: CR{
+				"error": "",
+				"response": CR{
+					"id": 43,
+				},
+			},
+		},
+		Case{ // повтор с тем же ключом - тот же ответ, а не 409
+			Path:   ApiUserCreate,
+			Method: http.MethodPost,
+			Query:  "login=idempotent_user&age=32&full_name=Ivan_Ivanov",
+			Status: http.StatusOK,
+			Auth:   true,
+			Header: map[string]string{"Idempotency-Key": "create-1"},
+			Result: CR{
+				"error": "",
+				"response": CR{
+					"id": 43,
+				},
+			},
+		},
+		Case{ // тот же ключ с другими параметрами
+			Path:   ApiUserCreate,
+			Method: http.MethodPost,
+			Query:  "login=idempotent_user2&age=32&full_name=Ivan_Ivanov",
+			Status: http.StatusUnprocessableEntity,
+			Auth:   true,
+			Header: map[string]string{"Idempotency-Key": "create-1"},
+			Result: CR{
+				"error": "idempotency key reused with different params",
+			},
+		},
+		Case{ // без ключа - обычное поведение
+			Path:   ApiUserCreate,
+			Method: http.MethodPost,
+			Query:  "login=idempotent_user&age=32&full_name=Ivan_Ivanov",
+			Status: http.StatusConflict,
+			Auth:   true,
+			Result: CR{
+				"error": "user idempotent_user exist",
+			},
+		},
+	}
+
+	runTests(t, ts, cases)
+}
+
+// inFlightStore reports every key as reserved by another request
+type inFlightStore struct{}
+
+func (inFlightStore) Reserve(key string) (*IdempotencyRecord, bool) { return nil, true }
+func (inFlightStore) Put(key string, rec *IdempotencyRecord)        {}
+func (inFlightStore) Release(key string)                            {}
+
+func TestMyApiIdempotencyInFlight(t *testing.T) {
+	ts := httptest.NewServer(NewMyApi())
+
+	store := ApiIdempotencyStore
+	ApiIdempotencyStore = inFlightStore{}
+	defer func() { ApiIdempotencyStore = store }()
+
+	runTests(t, ts, []Case{
+		Case{ // запрос с тем же ключом ещё выполняется
+			Path:   ApiUserCreate,
+			Method: http.MethodPost,
+			Query:  "login=in_flight_user&age=32&full_name=Ivan_Ivanov",
+			Status: http.StatusConflict,
+			Auth:   true,
+			Header: map[string]string{"Idempotency-Key": "create-1"},
+			Result: CR{
+				"error": "request with this idempotency key is in progress",
+			},
+		},
+	})
+}
+
+func TestMyApiIdempotencyConcurrent(t *testing.T) {
+	ts := httptest.NewServer(NewMyApi())
+
+	store := ApiIdempotencyStore
+	ApiIdempotencyStore = NewLRUIdempotencyStore(16)
+	defer func() { ApiIdempotencyStore = store }()
+
+	// одновременные запросы с одним ключом вызывают метод один раз
+	bodies := make(chan string, 20)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < cap(bodies); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, _ := http.NewRequest(http.MethodPost, ts.URL+ApiUserCreate, strings.NewReader("login=concurrent_user&age=32&full_name=Ivan_Ivanov"))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set("X-Auth", "100500")
+			req.Header.Set("Idempotency-Key", "concurrent-1")
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Errorf("request error: %v", err)
+				return
+			}
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			bodies <- string(body)
+		}()
+	}
+	wg.Wait()
+	close(bodies)
+
+	for body := range bodies {
+		if body != `{"error":"","response":{"id":43}}` && body != `{"error":"request with this idempotency key is in progress"}` {
+			t.Errorf("unexpected response %s", body)
+		}
+	}
+}
+
+func TestLRUIdempotencyStore(t *testing.T) {
+	s := NewLRUIdempotencyStore(1)
+	if rec, inFlight := s.Reserve("a"); rec != nil || inFlight {
+		t.Fatalf("first Reserve: got %v, %v, expected nil, false", rec, inFlight)
+	}
+	if rec, inFlight := s.Reserve("a"); rec != nil || !inFlight {
+		t.Fatalf("Reserve of a reserved key: got %v, %v, expected nil, true", rec, inFlight)
+	}
+	s.Release("a")
+	if _, inFlight := s.Reserve("a"); inFlight {
+		t.Fatalf("Reserve after Release: key is still reserved")
+	}
+	rec := &IdempotencyRecord{"f", http.StatusOK, []byte("{}")}
+	s.Put("a", rec)
+	s.Release("a")
+	if got, inFlight := s.Reserve("a"); got != rec || inFlight {
+		t.Fatalf("Reserve after Put: got %v, %v, expected the record", got, inFlight)
+	}
+
+	// reserved keys are not evicted by stored ones
+	s.Reserve("b")
+	s.Reserve("c")
+	s.Put("c", rec)
+	if _, inFlight := s.Reserve("b"); !inFlight {
+		t.Fatalf("reserved key was evicted")
+	}
+}
+
+func TestMyApiCors(t *testing.T) {
+	ts := httptest.NewServer(NewMyApi())
+
+	// preflight с разрешённого origin
+	req, _ := http.NewRequest(http.MethodOptions, ts.URL+ApiUserCreate, nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("expected http status %v, got %v", http.StatusNoContent, resp.StatusCode)
+	}
+	expected := map[string]string{
+		"Access-Control-Allow-Origin":      "https://app.example.com",
+		"Access-Control-Allow-Credentials": "true",
+		"Access-Control-Allow-Methods":     "GET, POST",
+		"Access-Control-Allow-Headers":     "X-Auth, Content-Type, Idempotency-Key",
+		"Access-Control-Max-Age":           "600",
+	}
+	for k, v := range expected {
+		if got := resp.Header.Get(k); got != v {
+			t.Errorf("expected %s %q, got %q", k, v, got)
+		}
+	}
+
+	// preflight с чужого origin - без CORS заголовков
+	req.Header.Set("Origin", "https://evil.example.com")
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	resp.Body.Close()
+	if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("expected no Access-Control-Allow-Origin, got %q", got)
+	}
+
+	// preflight неизвестного пути
+	req, _ = http.NewRequest(http.MethodOptions, ts.URL+"/user/unknown", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected http status %v, got %v", http.StatusNotFound, resp.StatusCode)
+	}
+
+	// обычный запрос
+	req, _ = http.NewRequest(http.MethodGet, ts.URL+ApiUserProfile+"?login=rvasily", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected http status %v, got %v", http.StatusOK, resp.StatusCode)
+	}
+	if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+		t.Errorf("expected Access-Control-Allow-Origin %q, got %q", "https://app.example.com", got)
+	}
+	if got := resp.Header.Get("Access-Control-Expose-Headers"); got != "ETag" {
+		t.Errorf("expected Access-Control-Expose-Headers %q, got %q", "ETag", got)
+	}
+}
+
+func TestStatusApi(t *testing.T) {
+	ts := httptest.NewServer(NewStatusApi())
+
+	cases := []Case{
+		Case{ // метод возвращает только ошибку
+			Path:   "/status/ping",
+			Status: http.StatusOK,
+			Result: CR{
+				"error": "",
+			},
+		},
+		Case{ // слайс в ответе
+			Path:   "/status/list",
+			Status: http.StatusOK,
+			Result: CR{
+				"error":    "",
+				"response": []string{"admin", "moderator", "user"},
+			},
+		},
+		Case{ // map в ответе
+			Path:   "/status/levels",
+			Status: http.StatusOK,
+			Result: CR{
+				"error": "",
+				"response": CR{
+					"user":      0,
+					"moderator": 10,
+					"admin":     20,
+				},
+			},
+		},
+		Case{ // параметры по указателю, структура в ответе
+			Path:   "/status/get",
+			Query:  "name=moderator",
+			Status: http.StatusOK,
+			Result: CR{
+				"error": "",
+				"response": CR{
+					"name":  "moderator",
+					"level": 10,
+				},
+			},
+		},
+		Case{
+			Path:   "/status/get",
+			Query:  "name=root",
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "name must be one of [user, moderator, admin]",
+			},
+		},
+	}
+
+	runTests(t, ts, cases)
+}
+
+func TestMyApiUploadAvatar(t *testing.T) {
+	ts := httptest.NewServer(NewMyApi())
+
+	png := append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 100)...)
+
+	cases := []struct {
+		Fields map[string]string
+		Files  map[string][]byte
+		Status int
+		Result interface{}
+	}{
+		{ // успешная загрузка
+			Fields: map[string]string{"login": "rvasily"},
+			Files:  map[string][]byte{"avatar": png, "thumb": png[:20]},
+			Status: http.StatusOK,
+			Result: CR{
+				"error": "",
+				"response": CR{
+					"login":      "rvasily",
+					"size":       len(png),
+					"thumb_size": 20,
+				},
+			},
+		},
+		{ // нет файла
+			Fields: map[string]string{"login": "rvasily"},
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "avatar must me not empty",
+			},
+		},
+		{ // не картинка
+			Fields: map[string]string{"login": "rvasily"},
+			Files:  map[string][]byte{"avatar": []byte("just text")},
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "avatar must be one of types [image/png, image/jpeg]",
+			},
+		},
+		{ // слишком большой файл
+			Fields: map[string]string{"login": "rvasily"},
+			Files:  map[string][]byte{"avatar": append(png, make([]byte, 64<<10)...)},
+			Status: http.StatusRequestEntityTooLarge,
+			Result: CR{
+				"error": "avatar size must be <= 64KB",
+			},
+		},
+		{ // []byte поле тоже проверяется
+			Fields: map[string]string{"login": "rvasily"},
+			Files:  map[string][]byte{"avatar": png, "thumb": make([]byte, 9<<10)},
+			Status: http.StatusRequestEntityTooLarge,
+			Result: CR{
+				"error": "thumb size must be <= 8KB",
+			},
+		},
+		{ // тело больше суммы max_size полей, не читается целиком
+			Fields: map[string]string{"login": "rvasily"},
+			Files:  map[string][]byte{"avatar": append(png, make([]byte, 4<<20)...)},
+			Status: http.StatusRequestEntityTooLarge,
+			Result: CR{
+				"error": "request body size must be <= 139264 bytes",
+			},
+		},
+	}
+
+	for idx, item := range cases {
+		body := &bytes.Buffer{}
+		mw := multipart.NewWriter(body)
+		for k, v := range item.Fields {
+			mw.WriteField(k, v)
+		}
+		for k, v := range item.Files {
+			fw, _ := mw.CreateFormFile(k, k+".png")
+			fw.Write(v)
+		}
+		mw.Close()
+
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+"/user/avatar", body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		req.Header.Set("X-Auth", "100500")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Errorf("[%d] request error: %v", idx, err)
+			continue
+		}
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != item.Status {
+			t.Errorf("[%d] expected http status %v, got %v", idx, item.Status, resp.StatusCode)
+			continue
+		}
+
+		var result, expected interface{}
+		json.Unmarshal(respBody, &result)
+		data, _ := json.Marshal(item.Result)
+		json.Unmarshal(data, &expected)
+		if !reflect.DeepEqual(result, expected) {
+			t.Errorf("[%d] results not match\nGot: %#v\nExpected: %#v", idx, result, item.Result)
+		}
+	}
+}
+
+func TestMyApiVersions(t *testing.T) {
+	ts := httptest.NewServer(NewMyApi())
+
+	cases := []Case{
+		Case{ // v1 - старый метод
+			Path:   "/v1" + ApiUserCreate,
+			Method: http.MethodPost,
+			Query:  "login=v1_moderator&age=32&status=moderator&full_name=Ivan_Ivanov",
+			Status: http.StatusOK,
+			Auth:   true,
+			Result: CR{
+				"error": "",
+				"response": CR{
+					"id": 43,
+				},
+			},
+		},
+		Case{ // v2 - новый метод с другими параметрами
+			Path:   "/v2" + ApiUserCreate,
+			Method: http.MethodPost,
+			Query:  "login=v2_moderator&status=moderator",
+			Status: http.StatusBadRequest,
+			Auth:   true,
+			Result: CR{
+				"error": "full_name must me not empty",
+			},
+		},
+		Case{
+			Path:   "/v2" + ApiUserCreate,
+			Method: http.MethodPost,
+			Query:  "login=v2_moderator&status=moderator&full_name=Ivan_Ivanov",
+			Status: http.StatusOK,
+			Auth:   true,
+			Result: CR{
+				"error": "",
+				"response": CR{
+					"id":        44,
+					"login":     "v2_moderator",
+					"full_name": "Ivan_Ivanov",
+					"status":    10,
+				},
+			},
+		},
+		Case{ // неизвестная версия
+			Path:   "/v3" + ApiUserCreate,
+			Method: http.MethodPost,
+			Query:  "login=v3_moderator&full_name=Ivan_Ivanov",
+			Status: http.StatusNotFound,
+			Auth:   true,
+			Result: CR{
+				"error": "unknown method",
+			},
+		},
+	}
+
+	runTests(t, ts, cases)
+
+	// устаревшие эндпоинты помечаются заголовками
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/v1"+ApiUserCreate, nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	resp.Body.Close()
+	if got := resp.Header.Get("Deprecation"); got != "true" {
+		t.Errorf("expected Deprecation header %q, got %q", "true", got)
+	}
+	if got := resp.Header.Get("Sunset"); got != "Thu, 31 Dec 2026 00:00:00 GMT" {
+		t.Errorf("expected Sunset header %q, got %q", "Thu, 31 Dec 2026 00:00:00 GMT", got)
+	}
+
+	req, _ = http.NewRequest(http.MethodPost, ts.URL+"/v2"+ApiUserCreate, nil)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	resp.Body.Close()
+	if got := resp.Header.Get("Deprecation"); got != "" {
+		t.Errorf("expected no Deprecation header, got %q", got)
+	}
+}
+
 func runTests(t *testing.T, ts *httptest.Server, cases []Case) {
 	for idx, item := range cases {
 		var (
@@ -308,6 +897,10 @@ func runTests(t *testing.T, ts *httptest.Server, cases []Case) {
 			req.Header.Add("X-Auth", "100500")
 		}
 
+		for k, v := range item.Header {
+			req.Header.Add(k, v)
+		}
+
 		resp, err := client.Do(req)
 		if err != nil {
 			t.Errorf("[%s] request error: %v", caseName, err)
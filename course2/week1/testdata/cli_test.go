@@ -0,0 +1,92 @@
+package main
+
+// Runs the command line client generated with -cli for all APIs, handlers_gen tests build it
+// and pass its path in CLI_BIN.
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestCLI(t *testing.T) {
+	bin := os.Getenv("CLI_BIN")
+	if bin == "" {
+		t.Skip("CLI_BIN is not set")
+	}
+	servers := map[string]*httptest.Server{
+		"myapi":     httptest.NewServer(NewMyApi()),
+		"otherapi":  httptest.NewServer(NewOtherApi()),
+		"statusapi": httptest.NewServer(NewStatusApi()),
+	}
+	for _, ts := range servers {
+		defer ts.Close()
+	}
+
+	cases := []struct {
+		Args   []string
+		Code   int
+		Output string
+	}{
+		{
+			Args:   []string{"myapi", "user", "profile", "-login", "rvasily"},
+			Output: `"full_name": "Vasily Romanov"`,
+		},
+		{ // необязательный int параметр можно не передавать
+			Args:   []string{"myapi", "user", "create", "-auth", "100500", "-login", "cli_user_login"},
+			Output: `"id": 43`,
+		},
+		{
+			Args:   []string{"myapi", "user", "create", "-login", "cli_user_login"},
+			Code:   1,
+			Output: `"error": "unauthorized"`,
+		},
+		{
+			Args:   []string{"otherapi", "user", "create", "-auth", "100500", "-username", "cli", "-level", "10"},
+			Output: `"level": 10`,
+		},
+		{
+			Args:   []string{"otherapi", "user", "create", "-auth", "100500", "-username", "cli"},
+			Code:   1,
+			Output: `"error": "level must be \u003e= 1"`,
+		},
+		{
+			Args:   []string{"statusapi", "status", "get", "-name", "moderator"},
+			Output: `"level": 10`,
+		},
+		{
+			Args:   []string{"user", "profile"},
+			Code:   2,
+			Output: "myapi user profile",
+		},
+	}
+
+	for idx, item := range cases {
+		args := item.Args
+		if ts, ok := servers[args[0]]; ok {
+			args = append(args, "-url", ts.URL)
+		}
+		out := &bytes.Buffer{}
+		cmd := exec.Command(bin, args...)
+		cmd.Stdout = out
+		cmd.Stderr = out
+		code := 0
+		if err := cmd.Run(); err != nil {
+			ee, ok := err.(*exec.ExitError)
+			if !ok {
+				t.Fatalf("[%d] %v", idx, err)
+			}
+			code = ee.ExitCode()
+		}
+		if code != item.Code {
+			t.Errorf("[%d] expected exit code %d, got %d\n%s", idx, item.Code, code, out)
+			continue
+		}
+		if !strings.Contains(out.String(), item.Output) {
+			t.Errorf("[%d] output does not contain %s\n%s", idx, item.Output, out)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+// Tests of the handlers generated with -templates templates, which overrides the envelope
+// of responses, handlers_gen tests run them.
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEnvelopeTemplate(t *testing.T) {
+	ts := httptest.NewServer(NewMyApi())
+	defer ts.Close()
+
+	cases := []struct {
+		Method string
+		Path   string
+		Status int
+		Body   string
+	}{
+		{http.MethodGet, "/user/profile?login=rvasily", http.StatusOK,
+			`{"ok":true,"data":{"id":42,"login":"rvasily","full_name":"Vasily Romanov","status":20}}`},
+		{http.MethodGet, "/user/profile", http.StatusBadRequest, `{"ok":false,"message":"login must me not empty"}`},
+		{http.MethodGet, "/user/profile?login=not_exist_user", http.StatusNotFound, `{"ok":false,"message":"user not exist"}`},
+		{http.MethodGet, "/user/create", http.StatusNotAcceptable, `{"ok":false,"message":"bad method"}`},
+		{http.MethodPost, "/user/create", http.StatusForbidden, `{"ok":false,"message":"unauthorized"}`},
+		{http.MethodGet, "/user/unknown", http.StatusNotFound, `{"ok":false,"message":"unknown method"}`},
+	}
+
+	for idx, item := range cases {
+		req, _ := http.NewRequest(item.Method, ts.URL+item.Path, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("[%d] request error: %v", idx, err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != item.Status {
+			t.Errorf("[%d] expected http status %v, got %v", idx, item.Status, resp.StatusCode)
+		}
+		if got := strings.TrimSpace(string(body)); got != item.Body {
+			t.Errorf("[%d] expected body %s, got %s", idx, item.Body, got)
+		}
+	}
+}
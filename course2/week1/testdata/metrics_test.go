@@ -0,0 +1,105 @@
+package main
+
+// Tests of the handlers generated with -metrics, handlers_gen tests run them with main_test.go.
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	ts := httptest.NewServer(NewMyApi())
+
+	cases := []Case{
+		Case{
+			Path:   ApiUserProfile,
+			Query:  "login=rvasily",
+			Status: http.StatusOK,
+			Result: CR{
+				"error": "",
+				"response": CR{
+					"id":        42,
+					"login":     "rvasily",
+					"full_name": "Vasily Romanov",
+					"status":    20,
+				},
+			},
+		},
+		Case{
+			Path:   ApiUserCreate,
+			Method: http.MethodGet,
+			Status: http.StatusNotAcceptable,
+			Result: CR{
+				"error": "bad method",
+			},
+		},
+		Case{
+			Path:   ApiUserCreate,
+			Method: http.MethodPost,
+			Status: http.StatusForbidden,
+			Result: CR{
+				"error": "unauthorized",
+			},
+		},
+		Case{
+			Path:   ApiUserProfile,
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "login must me not empty",
+			},
+		},
+		Case{
+			Path:   ApiUserProfile,
+			Query:  "login=not_exist_user",
+			Status: http.StatusNotFound,
+			Result: CR{
+				"error": "user not exist",
+			},
+		},
+	}
+
+	runTests(t, ts, cases)
+
+	rec := httptest.NewRecorder()
+	MetricsHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+
+	expected := []string{
+		`api_requests_total{api="MyApi",handler="handlerProfile",code="200",outcome="success"}`,
+		`api_requests_total{api="MyApi",handler="handlerCreate",code="406",outcome="bad_method"}`,
+		`api_requests_total{api="MyApi",handler="handlerCreate",code="403",outcome="unauthorized"}`,
+		`api_requests_total{api="MyApi",handler="handlerProfile",code="400",outcome="validation"}`,
+		`api_requests_total{api="MyApi",handler="handlerProfile",code="404",outcome="method_error"}`,
+		`api_request_duration_seconds_bucket{api="MyApi",handler="handlerProfile",le="+Inf"}`,
+		`api_requests_in_flight{api="MyApi",handler="handlerProfile"} 0`,
+	}
+	for _, e := range expected {
+		if !strings.Contains(body, e) {
+			t.Errorf("metrics output does not contain %s\n%s", e, body)
+		}
+	}
+}
+
+func TestMetricsStatusWriter(t *testing.T) {
+	rec := httptest.NewRecorder()
+	sw, _ := apiMetrics.Begin("MyApi", "handlerProfile", rec)
+	var w http.ResponseWriter = sw
+	f, ok := w.(http.Flusher)
+	if !ok {
+		t.Fatalf("apiStatusWriter does not implement http.Flusher")
+	}
+	f.Flush()
+	if !rec.Flushed {
+		t.Errorf("Flush is not forwarded to the underlying writer")
+	}
+	h, ok := w.(http.Hijacker)
+	if !ok {
+		t.Fatalf("apiStatusWriter does not implement http.Hijacker")
+	}
+	if _, _, err := h.Hijack(); err == nil {
+		t.Errorf("expected error hijacking httptest.ResponseRecorder")
+	}
+	apiMetrics.End(sw, "success")
+}
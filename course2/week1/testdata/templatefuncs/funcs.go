@@ -0,0 +1,10 @@
+package main
+
+// An example of a template func added without changes to the generator: put this file
+// next to codegen.go and build as usual, go build handlers_gen/*.
+
+func init() {
+	registerTemplateFunc("jsonTag", func(name string) string {
+		return "`json:\"" + name + "\"`"
+	})
+}